@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// disk serialization version flag marking a CAddress as addrv2 (BIP155)
+const addrV2Format = 1 << 29
+
+// the longest address BIP155 allows
+const maxAddrV2Size = 512
+
+// readCAddress reads a disk serialized CAddress in either the original or
+// the addrv2 format, as flagged by its serialization version
+func (dbreader *DBReader) readCAddress() (cAddress CAddress) {
+	cAddress.SerializationVersion = dbreader.readBytes(4)
+	version := binary.LittleEndian.Uint32(cAddress.SerializationVersion)
+	cAddress.Time = dbreader.readUint32()
+
+	if version&addrV2Format == 0 {
+		cAddress.ServiceFlags = reverseBytes(dbreader.readBytes(8))
+		cAddress.PeerAddress.IPAddress = dbreader.readBytes(16)
+		cAddress.PeerAddress.Port = dbreader.readBigEndianUint16()
+		return
+	}
+
+	// addrv2 encodes services as a CompactSize and the address with its
+	// network id and length
+	cAddress.ServiceFlags = make([]byte, length_UINT64)
+	binary.BigEndian.PutUint64(cAddress.ServiceFlags, dbreader.readCompactSize())
+
+	cAddress.PeerAddress.NetworkID, cAddress.PeerAddress.IPAddress = dbreader.readAddrV2()
+	cAddress.PeerAddress.Port = dbreader.readBigEndianUint16()
+	return
+}
+
+// readAddrV2 reads a network id and address in the addrv2 format. IPv4 is
+// returned in its 16 byte form and Tor v2 behind the OnionCat prefix, as in
+// the legacy format; Tor v3, I2P and CJDNS addresses are returned as read.
+func (dbreader *DBReader) readAddrV2() (BIP155Network, net.IP) {
+	networkID := BIP155Network(dbreader.readUint8())
+	addr := dbreader.readBytes(dbreader.readCompactSize())
+	switch networkID {
+	case BIP155IPv4:
+		return networkID, net.IP(addr).To16()
+	case BIP155TorV2:
+		return networkID, append(append(net.IP{}, onionCatPrefix...), addr...)
+	}
+	return networkID, addr
+}
+
+// the size of a CAddress serialized in the legacy format
+const cAddressSize = 4 + 4 + 8 + 16 + 2
+
+// peekCAddrInfoSize returns the size of the CAddrInfo at the cursor, in
+// either format, without reading it. ok is false if the record runs past
+// the end of the bytes or declares an address longer than BIP155 allows.
+func (dbreader *DBReader) peekCAddrInfoSize() (size uint64, ok bool) {
+	peek := recordPeeker{b: dbreader.Bytes, cursor: dbreader.Cursor}
+	if !peek.cAddress() {
+		return 0, false
+	}
+	if !peek.addrV2Format {
+		return cAddrInfoSize, peek.fits(cAddrInfoSize - cAddressSize)
+	}
+	if !peek.addrV2() {
+		return 0, false
+	}
+	peek.cursor += length_UINT64 + length_UINT32 // last success and attempts
+	return peek.cursor - dbreader.Cursor, peek.fits(0)
+}
+
+// peekCAddressSize returns the size of the CAddress at the cursor like
+// peekCAddrInfoSize
+func (dbreader *DBReader) peekCAddressSize() (size uint64, ok bool) {
+	peek := recordPeeker{b: dbreader.Bytes, cursor: dbreader.Cursor}
+	if !peek.cAddress() {
+		return 0, false
+	}
+	return peek.cursor - dbreader.Cursor, true
+}
+
+// recordPeeker walks a record's variable length fields without decoding
+// them, checking that each fits in the bytes
+type recordPeeker struct {
+	b            []byte
+	cursor       uint64
+	addrV2Format bool
+}
+
+func (peek *recordPeeker) fits(n uint64) bool {
+	return peek.cursor+n <= uint64(len(peek.b))
+}
+
+func (peek *recordPeeker) compactSize() (uint64, bool) {
+	if !peek.fits(1) {
+		return 0, false
+	}
+	first := peek.b[peek.cursor]
+	peek.cursor++
+	var n uint64
+	switch first {
+	case 0xfd:
+		n = length_UINT16
+	case 0xfe:
+		n = length_UINT32
+	case 0xff:
+		n = length_UINT64
+	default:
+		return uint64(first), true
+	}
+	if !peek.fits(n) {
+		return 0, false
+	}
+	padded := make([]byte, length_UINT64)
+	copy(padded, peek.b[peek.cursor:peek.cursor+n])
+	peek.cursor += n
+	return binary.LittleEndian.Uint64(padded), true
+}
+
+func (peek *recordPeeker) addrV2() bool {
+	if !peek.fits(1) {
+		return false
+	}
+	peek.cursor++ // network id
+	length, ok := peek.compactSize()
+	if !ok || length > maxAddrV2Size || !peek.fits(length) {
+		return false
+	}
+	peek.cursor += length
+	return true
+}
+
+// cAddress moves past a CAddress in the format its serialization version
+// flags, recording which in addrV2Format
+func (peek *recordPeeker) cAddress() bool {
+	if !peek.fits(length_UINT32) {
+		return false
+	}
+	if binary.LittleEndian.Uint32(peek.b[peek.cursor:])&addrV2Format == 0 {
+		if !peek.fits(cAddressSize) {
+			return false
+		}
+		peek.cursor += cAddressSize
+		return true
+	}
+
+	peek.addrV2Format = true
+	peek.cursor += length_UINT32 + length_UINT32 // version and time
+	if !peek.fits(0) {
+		return false
+	}
+	if _, ok := peek.compactSize(); !ok {
+		return false
+	}
+	if !peek.addrV2() {
+		return false
+	}
+	peek.cursor += length_UINT16 // port
+	return peek.fits(0)
+}
@@ -0,0 +1,84 @@
+package main
+
+import "fmt"
+
+// AnchorsDB holds the outbound block-relay peers a node saved to
+// anchors.dat on shutdown, to reconnect to on startup
+type AnchorsDB struct {
+	Path         string     `json:"-"`
+	MessageBytes []byte     `json:"message_bytes"`
+	Anchors      []CAddress `json:"anchors"`
+}
+
+// NewAnchorsDB parses an anchors.dat file. Unlike peers.dat it has no
+// version, key or table counts after the network magic: just a CompactSize
+// counted vector of CAddress, always in the addrv2 disk format, and the
+// trailing checksum. Like NewPeersDB, a file ending early gives an error
+// wrapping ErrTruncated and one failing its checksum ErrChecksumMismatch,
+// along with the anchors read, and a count more records than the file could
+// hold ErrImplausibleCount.
+func NewAnchorsDB(path string) (AnchorsDB, error) {
+	anchorsDB := AnchorsDB{
+		Path: path,
+	}
+
+	dbbytes, err := readDBBytes(PeersDB{Path: path})
+	if err != nil {
+		return anchorsDB, fmt.Errorf("Couldn't read anchors file %s: %w", anchorsDB.Path, err)
+	}
+
+	if len(dbbytes) >= 4 && !isKnownMagic(dbbytes[:4]) {
+		return anchorsDB, fmt.Errorf("%w in anchors file %s", ErrBadMagic, anchorsDB.Path)
+	}
+	if len(dbbytes) < 4+1+checksumSize {
+		return anchorsDB, fmt.Errorf("%w parsing anchors file %s of %d bytes", ErrTruncated, anchorsDB.Path, len(dbbytes))
+	}
+
+	// before parsing rearranges the bytes
+	checksumValid := trailingChecksumMatches(dbbytes)
+
+	// the records run up to the checksum
+	end := len(dbbytes) - checksumSize
+	dbreader := DBReader{
+		Bytes:  dbbytes[:end],
+		Cursor: 0,
+	}
+
+	anchorsDB.MessageBytes = dbreader.readBytes(4)
+	peek := recordPeeker{b: dbreader.Bytes, cursor: dbreader.Cursor}
+	count, ok := peek.compactSize()
+	if !ok {
+		return anchorsDB, fmt.Errorf("%w parsing anchor count of %s", ErrTruncated, anchorsDB.Path)
+	}
+	dbreader.Cursor = peek.cursor
+	if remaining := uint64(end) - dbreader.Cursor; count > remaining/minCAddressSize {
+		return anchorsDB, fmt.Errorf("%w: %d anchors can't fit in the %d bytes of %s", ErrImplausibleCount, count, remaining, anchorsDB.Path)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if _, ok := dbreader.peekCAddressSize(); !ok {
+			return anchorsDB, fmt.Errorf("%w parsing anchor %d at byte offset %d of %s", ErrTruncated, i, dbreader.Cursor, anchorsDB.Path)
+		}
+		anchorsDB.Anchors = append(anchorsDB.Anchors, dbreader.readCAddress())
+	}
+
+	if !checksumValid {
+		return anchorsDB, fmt.Errorf("%w: %s may be corrupt", ErrChecksumMismatch, anchorsDB.Path)
+	}
+	return anchorsDB, nil
+}
+
+// the shortest CAddress in addrv2: version, time, a one byte services
+// CompactSize, network id, an empty address and the port
+const minCAddressSize = 4 + 4 + 1 + 1 + 1 + 2
+
+// AddrInfos wraps the anchors as CAddrInfo so they can be used with the
+// filters and exports written for the address tables. Only the Address is
+// populated, anchors.dat not recording sources or connection attempts.
+func (anchorsDB AnchorsDB) AddrInfos() []CAddrInfo {
+	infos := make([]CAddrInfo, len(anchorsDB.Anchors))
+	for i, anchor := range anchorsDB.Anchors {
+		infos[i].Address = anchor
+	}
+	return infos
+}
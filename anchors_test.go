@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// anchorsFile builds an anchors.dat of addrv2 CAddress records with its
+// checksum
+func anchorsFile(count uint64, records ...[]byte) []byte {
+	var b bytes.Buffer
+	b.Write(mainnetMagic)
+	writeCompactSize(&b, count)
+	for _, record := range records {
+		b.Write(record)
+	}
+	checksum := doubleSHA256(b.Bytes())
+	b.Write(checksum[:])
+	return b.Bytes()
+}
+
+func anchorRecord(addr []byte, port uint16) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(addrV2SerializationVersion))
+	binary.Write(&b, binary.LittleEndian, uint32(1700000000))
+	writeCompactSize(&b, NodeNetwork|NodeWitness)
+	b.WriteByte(byte(BIP155IPv4))
+	writeCompactSize(&b, uint64(len(addr)))
+	b.Write(addr)
+	binary.Write(&b, binary.BigEndian, port)
+	return b.Bytes()
+}
+
+func TestNewAnchorsDB(t *testing.T) {
+	data := anchorsFile(2, anchorRecord([]byte{1, 2, 3, 4}, 8333), anchorRecord([]byte{5, 6, 7, 8}, 8333))
+	anchorsDB, err := NewAnchorsDB(writeFixture(t, "anchors.dat", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchorsDB.Anchors) != 2 || anchorsDB.Anchors[1].PeerAddress.Key() != "5.6.7.8:8333" {
+		t.Errorf("got anchors %v", anchorsDB.Anchors)
+	}
+
+	empty, err := NewAnchorsDB(writeFixture(t, "anchors.dat", anchorsFile(0)))
+	if err != nil || len(empty.Anchors) != 0 {
+		t.Errorf("empty anchors: got %v, %v", empty.Anchors, err)
+	}
+}
+
+func TestNewAnchorsDBCorrupt(t *testing.T) {
+	valid := anchorsFile(2, anchorRecord([]byte{1, 2, 3, 4}, 8333), anchorRecord([]byte{5, 6, 7, 8}, 8333))
+	for _, test := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"magic only", mainnetMagic, ErrTruncated},
+		{"huge count", anchorsFile(65535), ErrImplausibleCount},
+		{"count past records", anchorsFile(2, anchorRecord([]byte{1, 2, 3, 4}, 8333), anchorRecord([]byte{5, 6, 7, 8}, 8333)[:16]), ErrTruncated},
+		{"record cut short", anchorsFile(1, anchorRecord([]byte{1, 2, 3, 4}, 8333)[:16]), ErrTruncated},
+		{"oversized address", anchorsFile(1, anchorRecord(make([]byte, maxAddrV2Size+1), 8333)), ErrTruncated},
+		{"file cut short", valid[:len(valid)-3], ErrTruncated},
+		{"flipped byte", append(append([]byte{}, valid[:20]...), append([]byte{valid[20] ^ 1}, valid[21:]...)...), ErrChecksumMismatch},
+		{"bad magic", append([]byte{0xde, 0xad, 0xbe, 0xef}, valid[4:]...), ErrBadMagic},
+	} {
+		if _, err := NewAnchorsDB(writeFixture(t, "anchors.dat", test.data)); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
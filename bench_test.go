@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var benchEntries = flag.Int("bench.entries", 20000, "new table entries of the generated peers.dat the benchmarks run on, a quarter as many tried")
+
+func BenchmarkNewPeersDB(b *testing.B) {
+	data := legacyFixture(*benchEntries, 1700000000).bytes()
+	path := writeFixture(b, "peers.dat", data)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewPeersDB(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkComputeStats(b *testing.B) {
+	peersDB := parseFixture(b, legacyFixture(*benchEntries, 1700000000))
+
+	// every other tabled address and as many untabled ones are reachable
+	var hosts []string
+	for i := 0; i < *benchEntries; i++ {
+		if i%2 == 0 {
+			hosts = append(hosts, net.IPv4(1, byte(i>>16), byte(i>>8), byte(i)).String())
+		}
+		hosts = append(hosts, net.IPv4(9, byte(i>>16), byte(i>>8), byte(i)).String())
+	}
+	bitnodes := writeBitnodes(b, hosts...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := ComputeStats(bitnodes, 1700000000, peersDB.NewAddrInfo, peersDB.TriedAddrInfo); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// a snapshot every 10 minutes over 2 years
+const benchTimestamps = 2 * 365 * 24 * 6
+
+func BenchmarkClosestBitnodeTS(b *testing.B) {
+	var lines strings.Builder
+	for i := 0; i < benchTimestamps; i++ {
+		lines.WriteString(strconv.Itoa(1600000000+i*600) + "\n")
+	}
+	path := writeFixture(b, "timestamps.txt", []byte(lines.String()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ClosestBitnodeTS(path, uint32(1600000000+(i%benchTimestamps)*600+299)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinSearch(b *testing.B) {
+	tsArray := make([]uint32, benchTimestamps)
+	for i := range tsArray {
+		tsArray[i] = uint32(1600000000 + i*600)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BinSearch(uint32(1600000000+(i%benchTimestamps)*600+299), tsArray)
+	}
+}
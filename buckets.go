@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// addrman's bucket geometry
+const (
+	newBucketCount = 1024
+	bucketSize     = 64
+)
+
+// RetainBuckets has the parser keep the new table's bucket layout in
+// PeersDB.NewBucketEntries. It is off by default as the layout takes an
+// entry per address reference, of which there can be several per address.
+var RetainBuckets = false
+
+// BucketEntry places a reference to a new table entry in a bucket. Index is
+// the entry's position in NewAddrInfo. peers.dat only records which entries
+// a bucket holds; Position is recomputed the way Core does when loading the
+// file, so two entries of a bucket may collide on one position, in which
+// case Core keeps the first.
+type BucketEntry struct {
+	Bucket   int
+	Position int
+	Index    int
+}
+
+// readNewBuckets reads the new table's bucket layout following the tried
+// entries: for each bucket a count and the indexes of the entries it holds
+func (dbreader *DBReader) readNewBuckets(peersDB PeersDB) ([]BucketEntry, error) {
+	remaining := func() uint64 {
+		return uint64(len(dbreader.Bytes)) - dbreader.Cursor
+	}
+
+	var entries []BucketEntry
+	for bucket := 0; bucket < int(peersDB.NewBuckets); bucket++ {
+		if remaining() < length_UINT32 {
+			return entries, fmt.Errorf("Bucket layout truncated at bucket %d", bucket)
+		}
+		size := dbreader.readUint32()
+		if size > bucketSize || uint64(size)*length_UINT32 > remaining() {
+			return entries, fmt.Errorf("Implausible size %d of bucket %d", size, bucket)
+		}
+		for i := uint32(0); i < size; i++ {
+			index := int(dbreader.readUint32())
+			if index >= len(peersDB.NewAddrInfo) {
+				return entries, fmt.Errorf("Bucket %d refers to new entry %d of %d", bucket, index, len(peersDB.NewAddrInfo))
+			}
+			position := BucketPosition(peersDB.NKey, true, bucket, peersDB.NewAddrInfo[index].Address.PeerAddress)
+			entries = append(entries, BucketEntry{bucket, position, index})
+		}
+	}
+	return entries, nil
+}
+
+// placeNewEntries sets the BucketIndex and BucketPosition of the new table
+// entries from the bucket layout read from the file. An address can be
+// referenced from several buckets; the first reference is used. Core only
+// keeps a layout of exactly newBucketCount buckets, rebucketing the table
+// otherwise, so other layouts leave the entries unplaced.
+func placeNewEntries(peersDB PeersDB, entries []BucketEntry) {
+	if peersDB.NewBuckets != newBucketCount {
+		return
+	}
+	for _, entry := range entries {
+		info := &peersDB.NewAddrInfo[entry.Index]
+		if info.BucketIndex == -1 {
+			info.BucketIndex, info.BucketPosition = entry.Bucket, entry.Position
+		}
+	}
+}
+
+// BucketOccupancy returns the number of addresses in each of the new
+// table's buckets, to spot skewed placement. It counts every reference in
+// the layout when it was retained with RetainBuckets, and each entry in the
+// bucket of its BucketIndex otherwise.
+func (peersDB PeersDB) BucketOccupancy() []int {
+	occupancy := make([]int, newBucketCount)
+	if peersDB.NewBucketEntries != nil {
+		for _, entry := range peersDB.NewBucketEntries {
+			if entry.Bucket < newBucketCount {
+				occupancy[entry.Bucket]++
+			}
+		}
+		return occupancy
+	}
+	for _, info := range peersDB.NewAddrInfo {
+		if info.BucketIndex >= 0 && info.BucketIndex < newBucketCount {
+			occupancy[info.BucketIndex]++
+		}
+	}
+	return occupancy
+}
+
+// BucketPosition computes the position of an address within a bucket as
+// Core's AddrInfo::GetBucketPosition does: the first 8 bytes, little endian,
+// of the double SHA256 of the key, 'N' or 'K' for the new or tried table,
+// the bucket and the address key, modulo the bucket size
+func BucketPosition(nKey []byte, isNew bool, bucket int, service CService) int {
+	table := byte('K')
+	if isNew {
+		table = 'N'
+	}
+
+	// the address key is the address, in its 16 byte form unless it is one
+	// only addrv2 can hold, and the big endian port, serialized as a vector
+	// with its CompactSize length
+	addr := service.IPAddress.To16()
+	if addr == nil {
+		addr = service.IPAddress
+	}
+	key := append(append([]byte{}, addr...), byte(service.Port>>8), byte(service.Port))
+
+	data := append([]byte{}, nKey...)
+	data = append(data, table)
+	data = binary.LittleEndian.AppendUint32(data, uint32(bucket))
+	data = append(data, byte(len(key)))
+	data = append(data, key...)
+
+	first := sha256.Sum256(data)
+	hash := sha256.Sum256(first[:])
+	return int(binary.LittleEndian.Uint64(hash[:8]) % bucketSize)
+}
+
+// WriteBucketEntries writes a Bucket,Position,Address row per reference in
+// the new table's bucket layout
+func WriteBucketEntries(w io.Writer, peersDB PeersDB) error {
+	rows := [][]string{{"Bucket", "Position", "Address"}}
+	for _, entry := range peersDB.NewBucketEntries {
+		rows = append(rows, []string{
+			strconv.Itoa(entry.Bucket),
+			strconv.Itoa(entry.Position),
+			peersDB.NewAddrInfo[entry.Index].Address.PeerAddress.Key(),
+		})
+	}
+	return writeDelimited(w, ",", rows...)
+}
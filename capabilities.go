@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// peers.dat format versions, as in Bitcoin Core's AddrMan::Format. All of
+// them are parsed. Entries are read in the legacy or the addrv2 encoding as
+// each one's serialization version flags, whatever the file's format;
+// formats before FormatDeterministic store the bucket count unobfuscated.
+// Like Core, a newer format is read as long as it declares it can still be
+// read as one of these, and rejected with ErrUnsupportedVersion otherwise.
+const (
+	FormatHistorical    = 0 // historic format, before commit e6b343d88
+	FormatDeterministic = 1 // for pre-asmap files
+	FormatASMap         = 2 // for files including asmap version
+	FormatBIP155        = 3 // same as FormatASMap plus addresses in addrv2 format
+	FormatMultiport     = 4 // addresses may be keyed by ip and port
+)
+
+// the newest format the parser implements
+const latestFormat = FormatMultiport
+
+// Since FormatBIP155 the byte after the version, once the key size, is the
+// oldest format a reader must implement to read the file, offset by
+// incompatibilityBase. Older files hold 32, the key size, which reads as 0.
+const incompatibilityBase = 32
+
+// LowestCompatible returns the oldest format a reader must implement to
+// read the file
+func (peersDB PeersDB) LowestCompatible() uint8 {
+	return peersDB.KeySize - incompatibilityBase
+}
+
+// checkVersion rejects files the parser can't read: those whose lowest
+// compatible format is newer than it implements
+func checkVersion(peersDB PeersDB) error {
+	if peersDB.KeySize < incompatibilityBase {
+		return fmt.Errorf("%w %d: corrupt compatibility byte %d", ErrUnsupportedVersion, peersDB.Version, peersDB.KeySize)
+	}
+	if peersDB.LowestCompatible() > latestFormat {
+		return fmt.Errorf("%w %d, which needs a format %d reader", ErrUnsupportedVersion, peersDB.Version, peersDB.LowestCompatible())
+	}
+	return nil
+}
+
+// Capability is something a peers.dat can only record from a given format
+// version on
+type Capability struct {
+	Name       string
+	MinVersion uint8
+}
+
+var (
+	// CapabilityAddrV2 covers Tor v3, I2P and CJDNS addresses, which the
+	// legacy 16 byte address format can't represent
+	CapabilityAddrV2 = Capability{"Tor v3, I2P and CJDNS addresses", FormatBIP155}
+	// CapabilityASMap covers bucketing by autonomous system
+	CapabilityASMap = Capability{"asmap bucketing", FormatASMap}
+	// CapabilityMultiport covers several entries for one IP on different
+	// ports
+	CapabilityMultiport = Capability{"multiple ports per address", FormatMultiport}
+)
+
+// ErrNotAvailable is returned for analyses the file's version can't support
+var ErrNotAvailable = errors.New("not available for this file version")
+
+// Supports reports whether the file's version records the capability
+func (peersDB PeersDB) Supports(capability Capability) bool {
+	return peersDB.Version >= capability.MinVersion
+}
+
+// Require returns an error wrapping ErrNotAvailable if the file's version
+// doesn't record the capability
+func (peersDB PeersDB) Require(capability Capability) error {
+	if peersDB.Supports(capability) {
+		return nil
+	}
+	return fmt.Errorf("%s: %w (version %d, needs %d)", capability.Name, ErrNotAvailable, peersDB.Version, capability.MinVersion)
+}
+
+// networkCapabilities lists the networks only some versions can record
+var networkCapabilities = map[Network]Capability{
+	NetworkI2P:   CapabilityAddrV2,
+	NetworkCJDNS: CapabilityAddrV2,
+}
+
+// SupportsNetwork reports whether the file's version can record addresses
+// on network, so that a count of zero for it means something
+func (peersDB PeersDB) SupportsNetwork(network Network) bool {
+	capability, ok := networkCapabilities[network]
+	return !ok || peersDB.Supports(capability)
+}
+
+// RequireNetwork is Require for the capability needed by network
+func (peersDB PeersDB) RequireNetwork(network Network) error {
+	if capability, ok := networkCapabilities[network]; ok {
+		return peersDB.Require(capability)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import "time"
+
+// Clock reports the current time. Code that compares against "now" should
+// go through a Clock so that it can be driven deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that always reports the same instant
+type FixedClock struct {
+	T time.Time
+}
+
+func (c FixedClock) Now() time.Time {
+	return c.T
+}
+
+// SystemClock is the Clock used when none is supplied. It defaults to wall
+// time and may be replaced, e.g. with a FixedClock.
+var SystemClock Clock = wallClock{}
+
+// AgeReference picks the instant ages are measured from, given the approx
+// age of the file
+type AgeReference func(approxAge uint32) uint32
+
+// ReferenceApproxAge measures ages from the file's approx age, the time it
+// was most likely saved. This is the default and suits archived files.
+func ReferenceApproxAge(approxAge uint32) uint32 {
+	return approxAge
+}
+
+// ReferenceNow measures ages from the clock's current time, which suits a
+// live node's file. On an archived file every age grows by the time since
+// it was saved, pushing most entries into the oldest bucket.
+func ReferenceNow(clock Clock) AgeReference {
+	return func(uint32) uint32 {
+		return uint32(clock.Now().Unix())
+	}
+}
+
+// ReferenceFixed measures ages from the given unix time
+func ReferenceFixed(ts uint32) AgeReference {
+	return func(uint32) uint32 {
+		return ts
+	}
+}
+
+// StatsReference is the reference ComputeStats measures ages from
+var StatsReference AgeReference = ReferenceApproxAge
@@ -14,11 +14,13 @@ type BitPeersDB bitpeers.PeersDB
 var peersFilePath string
 var formatOption string
 var addressOnly bool
+var prettyJSON bool
 
 func init() {
 	flag.StringVar(&peersFilePath, "filepath", "", "the path to peers.dat")
 	flag.StringVar(&formatOption, "format", "json", "the output format {json|text}")
 	flag.BoolVar(&addressOnly, "addressonly", false, "outputs only addresses if specified")
+	flag.BoolVar(&prettyJSON, "pretty", false, "indent the JSON output")
 	flag.Parse()
 }
 
@@ -35,7 +37,8 @@ func main() {
 
 	rawPeersDB, err := bitpeers.NewPeersDB(peersFilePath)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	peersDb := BitPeersDB(rawPeersDB)
@@ -56,7 +59,7 @@ func main() {
 			}
 			return
 		} else {
-			encodedPeers, err := json.Marshal(addressArray)
+			encodedPeers, err := marshalJSON(addressArray)
 			if err != nil {
 				fmt.Sprintf("Error converting to JSON: %s", err)
 				os.Exit(1)
@@ -69,7 +72,7 @@ func main() {
 	if formatOption == "text" {
 		peersDb.dump()
 	} else {
-		encodedPeers, err := json.Marshal(peersDb)
+		encodedPeers, err := marshalJSON(peersDb)
 		if err != nil {
 			fmt.Sprintf("Error converting to JSON: %s", err)
 			os.Exit(1)
@@ -101,6 +104,13 @@ func (peersDB BitPeersDB) dump() {
 	}
 }
 
+func marshalJSON(v interface{}) ([]byte, error) {
+	if prettyJSON {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
 func hexstring(input []byte) string {
 	return hex.EncodeToString(input)
 }
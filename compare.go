@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// addressKeys returns the set of host:port keys across both tables
+func (peersDB PeersDB) addressKeys() map[string]bool {
+	keys := make(map[string]bool, len(peersDB.NewAddrInfo)+len(peersDB.TriedAddrInfo))
+	peersDB.Iterate(func(info CAddrInfo, _ TableKind) bool {
+		keys[info.Address.PeerAddress.Key()] = true
+		return true
+	})
+	return keys
+}
+
+// SimilarityJaccard returns the Jaccard index of the two nodes' address
+// sets: the number of host:port keys known to both divided by the number
+// known to either. The new and tried tables are combined, since an address
+// can move between them without the node's view of the network changing.
+// Two empty databases have a similarity of 0.
+func SimilarityJaccard(a, b PeersDB) float64 {
+	aKeys := a.addressKeys()
+	bKeys := b.addressKeys()
+
+	intersection := 0
+	for key := range aKeys {
+		if bKeys[key] {
+			intersection++
+		}
+	}
+
+	union := len(aKeys) + len(bKeys) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// TableHash returns a hex SHA-256 digest of the set of host:port keys in
+// table, which is "new", "tried" or "all" for both combined. The keys are
+// deduplicated and sorted first, so snapshots holding the same addresses
+// hash identically whatever order they were written in.
+func (peersDB PeersDB) TableHash(table string) (string, error) {
+	var keys map[string]bool
+	switch table {
+	case "new":
+		keys = PeersDB{NewAddrInfo: peersDB.NewAddrInfo}.addressKeys()
+	case "tried":
+		keys = PeersDB{TriedAddrInfo: peersDB.TriedAddrInfo}.addressKeys()
+	case "all":
+		keys = peersDB.addressKeys()
+	default:
+		return "", fmt.Errorf("Unknown table %s", table)
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	hash := sha256.New()
+	for _, key := range sorted {
+		hash.Write([]byte(key + "\n"))
+	}
+	return hexstring(hash.Sum(nil)), nil
+}
+
+// TableOverlap counts the addresses present in both tables
+type TableOverlap struct {
+	Count int
+	// TriedFraction is the share of tried addresses also in new, and
+	// NewFraction the share of new addresses also in tried
+	TriedFraction float64
+	NewFraction   float64
+}
+
+// TriedNewOverlap compares the tables by host:port key. Only the tried
+// table is loaded into a map; the new table is streamed against it.
+func (peersDB PeersDB) TriedNewOverlap() TableOverlap {
+	tried := PeersDB{TriedAddrInfo: peersDB.TriedAddrInfo}.addressKeys()
+
+	var overlap TableOverlap
+	seen := make(map[string]bool)
+	newKeys := 0
+	for _, info := range peersDB.NewAddrInfo {
+		key := info.Address.PeerAddress.Key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		newKeys++
+		if tried[key] {
+			overlap.Count++
+		}
+	}
+
+	if len(tried) > 0 {
+		overlap.TriedFraction = float64(overlap.Count) / float64(len(tried))
+	}
+	if newKeys > 0 {
+		overlap.NewFraction = float64(overlap.Count) / float64(newKeys)
+	}
+	return overlap
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// banlistJSON is the layout of banlist.json
+type banlistJSON struct {
+	BannedNets []struct {
+		Address string `json:"address"`
+	} `json:"banned_nets"`
+}
+
+// ReadBanlist parses the subnets banned in a banlist.json file
+func ReadBanlist(path string) ([]*net.IPNet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read banlist %s", path)
+	}
+
+	var banlist banlistJSON
+	if err := json.Unmarshal(data, &banlist); err != nil {
+		return nil, fmt.Errorf("Couldn't parse banlist %s: %s", path, err)
+	}
+
+	var banned []*net.IPNet
+	for _, entry := range banlist.BannedNets {
+		address := entry.Address
+		// single addresses may be written without a prefix length
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				address += "/32"
+			} else {
+				address += "/128"
+			}
+		}
+
+		_, subnet, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid banned subnet %s in %s", entry.Address, path)
+		}
+		banned = append(banned, subnet)
+	}
+	return banned, nil
+}
+
+// AnchorStatus describes where an anchor peer sits in the address tables
+type AnchorStatus struct {
+	Address   CAddress
+	InNew     bool
+	InTried   bool
+	Reachable bool
+}
+
+// DatadirReport cross-references peers.dat against the rest of a datadir
+type DatadirReport struct {
+	Anchors     []AnchorStatus
+	BannedNew   []CAddrInfo
+	BannedTried []CAddrInfo
+}
+
+// CompareDatadir checks whether each anchor is still tabled and reachable,
+// and which tabled addresses fall in a banned subnet. reachable holds the
+// reachable hosts as keyed by reachabilityKey and may be nil.
+func CompareDatadir(peersDB PeersDB, anchors []CAddress, banned []*net.IPNet, reachable map[string]bool) DatadirReport {
+	newKeys := PeersDB{NewAddrInfo: peersDB.NewAddrInfo}.addressKeys()
+	triedKeys := PeersDB{TriedAddrInfo: peersDB.TriedAddrInfo}.addressKeys()
+
+	var report DatadirReport
+	for _, anchor := range anchors {
+		key := anchor.PeerAddress.Key()
+		report.Anchors = append(report.Anchors, AnchorStatus{
+			Address:   anchor,
+			InNew:     newKeys[key],
+			InTried:   triedKeys[key],
+			Reachable: reachable[reachabilityKey(anchor.PeerAddress)],
+		})
+	}
+
+	isBanned := func(info CAddrInfo) bool {
+		for _, subnet := range banned {
+			if subnet.Contains(info.Address.PeerAddress.IPAddress) {
+				return true
+			}
+		}
+		return false
+	}
+	report.BannedNew = Filter(peersDB.NewAddrInfo, isBanned)
+	report.BannedTried = Filter(peersDB.TriedAddrInfo, isBanned)
+
+	return report
+}
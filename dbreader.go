@@ -71,6 +71,20 @@ func (r *DBReader) readUint64() uint64 {
 	return val
 }
 
+// Reads Bitcoin's variable length CompactSize integer
+func (r *DBReader) readCompactSize() uint64 {
+	first := r.readUint8()
+	switch first {
+	case 0xfd:
+		return uint64(r.readUint16())
+	case 0xfe:
+		return uint64(r.readUint32())
+	case 0xff:
+		return r.readUint64()
+	}
+	return uint64(first)
+}
+
 func (r *DBReader) readByte() byte {
 	byteVal := r.Bytes[r.Cursor]
 	r.Cursor += 1
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDBReaderEndianness(t *testing.T) {
+	data := []byte{
+		0x01, 0x02, // uint16
+		0x20, 0x8d, // big endian uint16
+		0x01, 0x02, 0x03, 0x04, // uint32
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // uint64
+	}
+	dbreader := DBReader{Bytes: data}
+	if got := dbreader.readUint16(); got != 0x0201 {
+		t.Errorf("readUint16 = %#x, want 0x0201", got)
+	}
+	if got := dbreader.readBigEndianUint16(); got != 8333 {
+		t.Errorf("readBigEndianUint16 = %d, want 8333", got)
+	}
+	if got := dbreader.readUint32(); got != 0x04030201 {
+		t.Errorf("readUint32 = %#x, want 0x04030201", got)
+	}
+	if got := dbreader.readUint64(); got != 0x0807060504030201 {
+		t.Errorf("readUint64 = %#x, want 0x0807060504030201", got)
+	}
+}
+
+func TestReadCompactSize(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		want uint64
+	}{
+		{[]byte{0xfc}, 0xfc},
+		{[]byte{0xfd, 0x09, 0x04}, 0x0409},
+		{[]byte{0xfe, 0x01, 0x02, 0x03, 0x04}, 0x04030201},
+		{[]byte{0xff, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, 0x0807060504030201},
+	} {
+		dbreader := DBReader{Bytes: test.data}
+		if got := dbreader.readCompactSize(); got != test.want || dbreader.Cursor != uint64(len(test.data)) {
+			t.Errorf("readCompactSize(% x) = %#x reading %d bytes, want %#x", test.data, got, dbreader.Cursor, test.want)
+		}
+	}
+}
+
+// a legacy entry with every multi-byte field distinguishable by byte order
+var legacyEntryBytes = []byte{
+	0x61, 0xef, 0x02, 0x00, // serialization version 0x2ef61
+	0x00, 0xf1, 0x53, 0x65, // time 1700000000
+	0x09, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // services NODE_NETWORK|NODE_WITNESS|NODE_NETWORK_LIMITED
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 1, 2, 3, 4, // 1.2.3.4
+	0x20, 0x8d, // port 8333, big endian
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 5, 6, 7, 8, // source 5.6.7.8
+	0xff, 0xf0, 0x53, 0x65, 0x00, 0x00, 0x00, 0x00, // last success 1699999999
+	0x03, 0x00, 0x00, 0x00, // attempts 3
+}
+
+func TestLegacyEntryByteOrder(t *testing.T) {
+	dbreader := DBReader{Bytes: append([]byte{}, legacyEntryBytes...)}
+	info := dbreader.readCAddrInfo()
+
+	if dbreader.Cursor != cAddrInfoSize {
+		t.Errorf("read %d bytes, want %d", dbreader.Cursor, cAddrInfoSize)
+	}
+	address := info.Address
+	if address.Time != 1700000000 {
+		t.Errorf("time = %d, want 1700000000", address.Time)
+	}
+	if want := NodeNetwork | NodeWitness | NodeNetworkLimited; address.Services() != want {
+		t.Errorf("services = %#x, want %#x", address.Services(), want)
+	}
+	if address.PeerAddress.Port != 8333 {
+		t.Errorf("port = %d, want 8333", address.PeerAddress.Port)
+	}
+	if got := address.PeerAddress.Key(); got != "1.2.3.4:8333" {
+		t.Errorf("address = %s, want 1.2.3.4:8333", got)
+	}
+	if got := info.Source.String(); got != "5.6.7.8" {
+		t.Errorf("source = %s, want 5.6.7.8", got)
+	}
+	if info.LastSuccess != 1699999999 || info.Attempts != 3 {
+		t.Errorf("last success %d and attempts %d, want 1699999999 and 3", info.LastSuccess, info.Attempts)
+	}
+
+	// and written back in the same byte order
+	var out bytes.Buffer
+	if err := writeCAddrInfo(&out, info); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), legacyEntryBytes) {
+		t.Errorf("wrote\n% x\nwant\n% x", out.Bytes(), legacyEntryBytes)
+	}
+	if port := out.Bytes()[32:34]; port[0] != 0x20 || port[1] != 0x8d {
+		t.Errorf("port written as % x, want 20 8d", port)
+	}
+}
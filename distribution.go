@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BucketCount is the number of addresses in one age bucket along with the
+// bucket's bounds, in seconds of age. Max is 0 for the open ended oldest
+// bucket.
+type BucketCount struct {
+	Label string
+	Min   uint32
+	Max   uint32
+	Count int
+}
+
+// DefaultAgeBoundaries are the 1, 5, 10 and 30 day bucket bounds
+var DefaultAgeBoundaries = []uint32{ONE_DAY, FIVE_DAYS, TEN_DAYS, THIRTY_DAYS}
+
+// AgeBoundaries are the bounds results bucket ages by, set with -age-buckets
+var AgeBoundaries = DefaultAgeBoundaries
+
+// NewAgeBuckets returns empty buckets split at boundaries, in seconds and
+// increasing, or at the DefaultAgeBoundaries if none are given
+func NewAgeBuckets(boundaries ...uint32) AgeBuckets {
+	if len(boundaries) == 0 {
+		boundaries = DefaultAgeBoundaries
+	}
+	return AgeBuckets{
+		Boundaries: boundaries,
+		Counts:     make([]int, len(boundaries)+1),
+	}
+}
+
+// AgeBucketIndex returns the index of the bucket age falls in. The youngest
+// bucket includes its bound, so that an entry exactly a day old is in the
+// first of the default buckets as it always has been; the others don't.
+func AgeBucketIndex(boundaries []uint32, age int) int {
+	if len(boundaries) > 0 && age <= int(boundaries[0]) {
+		return 0
+	}
+	for i := 1; i < len(boundaries); i++ {
+		if age < int(boundaries[i]) {
+			return i
+		}
+	}
+	return len(boundaries)
+}
+
+// ParseAgeBoundaries parses a comma separated list of bucket bounds in days,
+// which may be fractional, into seconds. The bounds must be increasing.
+func ParseAgeBoundaries(days string) ([]uint32, error) {
+	var boundaries []uint32
+	for _, field := range strings.Split(days, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || value <= 0 || value*ONE_DAY > math.MaxUint32 {
+			return nil, fmt.Errorf("Invalid age bucket bound %s", field)
+		}
+		boundary := uint32(value * ONE_DAY)
+		if len(boundaries) > 0 && boundary <= boundaries[len(boundaries)-1] {
+			return nil, fmt.Errorf("Age bucket bounds must be increasing: %s", days)
+		}
+		boundaries = append(boundaries, boundary)
+	}
+	return boundaries, nil
+}
+
+// ageLabel returns the column name of a bucket given its bounds in seconds,
+// Age_1_5 for one to five days, Age_1 for the youngest below a day and Age_30
+// for the oldest above thirty
+func ageLabel(min, max uint32) string {
+	days := func(seconds uint32) string {
+		return strconv.FormatFloat(float64(seconds)/ONE_DAY, 'f', -1, 64)
+	}
+	switch {
+	case min == 0:
+		return "Age_" + days(max)
+	case max == 0:
+		return "Age_" + days(min)
+	}
+	return "Age_" + days(min) + "_" + days(max)
+}
+
+// Buckets returns the buckets from youngest to oldest, labelled with their
+// output column names. Zero AgeBuckets are treated as empty buckets at the
+// AgeBoundaries.
+func (ageBuckets AgeBuckets) Buckets() []BucketCount {
+	if ageBuckets.Counts == nil {
+		ageBuckets = NewAgeBuckets(AgeBoundaries...)
+	}
+	bounds := append(append([]uint32{0}, ageBuckets.Boundaries...), 0)
+
+	buckets := make([]BucketCount, len(ageBuckets.Counts))
+	for i, count := range ageBuckets.Counts {
+		buckets[i] = BucketCount{
+			Label: ageLabel(bounds[i], bounds[i+1]),
+			Min:   bounds[i],
+			Max:   bounds[i+1],
+			Count: count,
+		}
+	}
+	return buckets
+}
+
+// counts returns the bucket counts from youngest to oldest
+func (ageBuckets AgeBuckets) counts() []int {
+	buckets := ageBuckets.Buckets()
+	counts := make([]int, len(buckets))
+	for i, bucket := range buckets {
+		counts[i] = bucket.Count
+	}
+	return counts
+}
+
+// defaultCount returns the count of the i-th of the default buckets, or -1
+// if the buckets are split at other boundaries
+func (ageBuckets AgeBuckets) defaultCount(i int) int {
+	buckets := ageBuckets.Buckets()
+	if len(buckets) != len(DefaultAgeBoundaries)+1 {
+		return -1
+	}
+	for j, boundary := range DefaultAgeBoundaries {
+		if buckets[j].Max != boundary {
+			return -1
+		}
+	}
+	return buckets[i].Count
+}
+
+// LessThanOne returns the count of addresses at most a day old, as the
+// field of that name did before -age-buckets. It and the other fixed
+// bucket accessors return -1 when custom boundaries are in use.
+func (ageBuckets AgeBuckets) LessThanOne() int { return ageBuckets.defaultCount(0) }
+
+// OneToFive returns the count of addresses one to five days old, see LessThanOne
+func (ageBuckets AgeBuckets) OneToFive() int { return ageBuckets.defaultCount(1) }
+
+// FiveToTen returns the count of addresses five to ten days old, see LessThanOne
+func (ageBuckets AgeBuckets) FiveToTen() int { return ageBuckets.defaultCount(2) }
+
+// TenToThirty returns the count of addresses ten to thirty days old, see LessThanOne
+func (ageBuckets AgeBuckets) TenToThirty() int { return ageBuckets.defaultCount(3) }
+
+// GreaterThanThirty returns the count of addresses over thirty days old, see LessThanOne
+func (ageBuckets AgeBuckets) GreaterThanThirty() int { return ageBuckets.defaultCount(4) }
+
+// AgeDistribution returns the share of the table's addresses in each age
+// bucket, youngest first. An empty table yields all zeros.
+func (result *Result) AgeDistribution() []float64 {
+	counts := result.Age.counts()
+	distribution := make([]float64, len(counts))
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return distribution
+	}
+
+	for i, count := range counts {
+		distribution[i] = float64(count) / float64(total)
+	}
+	return distribution
+}
+
+// CumulativeAgeDistribution returns, for each bucket's upper boundary, the
+// share of the table's addresses younger than it. The last value is 1 for
+// any non-empty table.
+func (result *Result) CumulativeAgeDistribution() []float64 {
+	distribution := result.AgeDistribution()
+	var sum float64
+	for i, share := range distribution {
+		sum += share
+		distribution[i] = sum
+	}
+	return distribution
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestAgeBucketAccessors(t *testing.T) {
+	const now = 1700000000
+	ageBuckets := NewAgeBuckets(DefaultAgeBoundaries...)
+	for _, age := range []uint32{ONE_DAY, 2 * ONE_DAY, 3 * ONE_DAY, 7 * ONE_DAY, 20 * ONE_DAY, 40 * ONE_DAY} {
+		AddToAgeBucket(&ageBuckets, now-age, now)
+	}
+	got := []int{ageBuckets.LessThanOne(), ageBuckets.OneToFive(), ageBuckets.FiveToTen(), ageBuckets.TenToThirty(), ageBuckets.GreaterThanThirty()}
+	want := []int{1, 2, 1, 1, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("default buckets: got %v, want %v", got, want)
+		}
+	}
+	if got := (AgeBuckets{}).OneToFive(); got != 0 {
+		t.Errorf("zero buckets: got %d, want 0", got)
+	}
+
+	custom := NewAgeBuckets(ONE_DAY, 7*ONE_DAY)
+	AddToAgeBucket(&custom, now-2*ONE_DAY, now)
+	if custom.LessThanOne() != -1 || custom.OneToFive() != -1 || custom.GreaterThanThirty() != -1 {
+		t.Errorf("custom buckets: got %d, %d, %d, want -1", custom.LessThanOne(), custom.OneToFive(), custom.GreaterThanThirty())
+	}
+}
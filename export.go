@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ExportAddnode writes infos as host:port lines suitable for bitcoin.conf
+// addnode= entries or -seednode, bracketing IPv6 hosts. Onion and other
+// overlay network addresses are skipped unless includeOverlay is set.
+func ExportAddnode(infos []CAddrInfo, w io.Writer, includeOverlay bool) error {
+	for _, info := range infos {
+		service := info.Address.PeerAddress
+		switch service.Network() {
+		case NetworkIPv4, NetworkIPv6:
+		case NetworkTor:
+			if !includeOverlay {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if _, err := fmt.Fprintln(w, service.Key()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReachableEntries returns the entries of table whose address was found
+// reachable when computing result
+func ReachableEntries(table []CAddrInfo, result *Result) []CAddrInfo {
+	reachable := make(map[string]bool, len(result.ReachableIPs))
+	for _, ip := range result.ReachableIPs {
+		reachable[ip] = true
+	}
+
+	return Filter(table, func(info CAddrInfo) bool {
+		return reachable[reachabilityKey(info.Address.PeerAddress)]
+	})
+}
+
+// SeedList orders infos freshest first for seeding a new node, keeping only
+// the first entry of each host:port
+func SeedList(infos []CAddrInfo) []CAddrInfo {
+	seen := make(map[string]bool, len(infos))
+	seeds := Filter(infos, func(info CAddrInfo) bool {
+		key := info.Address.PeerAddress.Key()
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	})
+
+	sort.SliceStable(seeds, func(i, j int) bool {
+		return seeds[i].Address.Time > seeds[j].Address.Time
+	})
+	return seeds
+}
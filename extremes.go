@@ -0,0 +1,62 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// addrHeap is a heap of entries ordered by less
+type addrHeap struct {
+	infos []CAddrInfo
+	less  func(a, b CAddrInfo) bool
+}
+
+func (h addrHeap) Len() int            { return len(h.infos) }
+func (h addrHeap) Less(i, j int) bool  { return h.less(h.infos[i], h.infos[j]) }
+func (h addrHeap) Swap(i, j int)       { h.infos[i], h.infos[j] = h.infos[j], h.infos[i] }
+func (h *addrHeap) Push(x interface{}) { h.infos = append(h.infos, x.(CAddrInfo)) }
+func (h *addrHeap) Pop() interface{} {
+	last := h.infos[len(h.infos)-1]
+	h.infos = h.infos[:len(h.infos)-1]
+	return last
+}
+
+// topN returns the n entries which sort first under before, in that order.
+// It keeps a bounded heap of the best n seen so far, so it runs in
+// O(len(table) log n) without sorting or copying the whole table.
+func topN(table []CAddrInfo, n int, before func(a, b CAddrInfo) bool) []CAddrInfo {
+	if n <= 0 {
+		return nil
+	}
+
+	// the root of the heap is the worst of the entries kept
+	h := &addrHeap{
+		infos: make([]CAddrInfo, 0, n),
+		less:  func(a, b CAddrInfo) bool { return before(b, a) },
+	}
+	for _, info := range table {
+		if h.Len() < n {
+			heap.Push(h, info)
+		} else if before(info, h.infos[0]) {
+			h.infos[0] = info
+			heap.Fix(h, 0)
+		}
+	}
+
+	sort.Slice(h.infos, func(i, j int) bool { return before(h.infos[i], h.infos[j]) })
+	return h.infos
+}
+
+// OldestN returns the n entries with the oldest timestamps, oldest first
+func OldestN(table []CAddrInfo, n int) []CAddrInfo {
+	return topN(table, n, func(a, b CAddrInfo) bool {
+		return a.Address.Time < b.Address.Time
+	})
+}
+
+// NewestN returns the n entries with the newest timestamps, newest first
+func NewestN(table []CAddrInfo, n int) []CAddrInfo {
+	return topN(table, n, func(a, b CAddrInfo) bool {
+		return a.Address.Time > b.Address.Time
+	})
+}
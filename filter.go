@@ -0,0 +1,97 @@
+package main
+
+import "regexp"
+
+// Predicate reports whether an entry should be kept by Filter
+type Predicate func(CAddrInfo) bool
+
+// Filter returns the entries of infos which satisfy every predicate. With no
+// predicates all entries are returned. The input slice is not modified.
+func Filter(infos []CAddrInfo, preds ...Predicate) []CAddrInfo {
+	var filtered []CAddrInfo
+	for _, info := range infos {
+		if matchesAll(info, preds) {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered
+}
+
+func matchesAll(info CAddrInfo, preds []Predicate) bool {
+	for _, pred := range preds {
+		if !pred(info) {
+			return false
+		}
+	}
+	return true
+}
+
+// Not inverts a predicate
+func Not(pred Predicate) Predicate {
+	return func(info CAddrInfo) bool {
+		return !pred(info)
+	}
+}
+
+// ByNetwork keeps entries whose address is on one of the given networks
+func ByNetwork(networks ...Network) Predicate {
+	return func(info CAddrInfo) bool {
+		network := info.Address.PeerAddress.Network()
+		for _, n := range networks {
+			if network == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByServiceFlag keeps entries advertising all of the given service bits
+func ByServiceFlag(flag uint64) Predicate {
+	return func(info CAddrInfo) bool {
+		return info.Address.Services()&flag == flag
+	}
+}
+
+// FilterByService returns the entries of both tables, new first, that
+// advertise all of the service bits in flag, e.g. NodeCompactFilters for
+// peers serving BIP157 filters
+func (peersDB PeersDB) FilterByService(flag uint64) []CAddrInfo {
+	return append(Filter(peersDB.NewAddrInfo, ByServiceFlag(flag)), Filter(peersDB.TriedAddrInfo, ByServiceFlag(flag))...)
+}
+
+// NewerThan keeps entries whose timestamp is strictly after ts
+func NewerThan(ts uint32) Predicate {
+	return func(info CAddrInfo) bool {
+		return info.Address.Time > ts
+	}
+}
+
+// MaxAge keeps entries timestamped at most seconds before reference.
+// Entries newer than reference are kept.
+func MaxAge(reference, seconds uint32) Predicate {
+	return func(info CAddrInfo) bool {
+		return info.Address.Time >= reference || reference-info.Address.Time <= seconds
+	}
+}
+
+// Routable keeps entries whose address is publicly routable
+func Routable() Predicate {
+	return func(info CAddrInfo) bool {
+		return info.Address.PeerAddress.IsRoutable()
+	}
+}
+
+// HasValidPort reports whether the entry carries a dialable port. Port 0 is
+// used as a placeholder and never accepts connections.
+func HasValidPort(info CAddrInfo) bool {
+	return info.Address.PeerAddress.Port != 0
+}
+
+// HostMatches keeps entries whose normalized host, as produced by
+// NormalizeHostKey, matches the regular expression
+func HostMatches(re *regexp.Regexp) Predicate {
+	return func(info CAddrInfo) bool {
+		return re.MatchString(NormalizeHostKey(info.Address.PeerAddress.Host()))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func hosts(infos []CAddrInfo) []string {
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Address.PeerAddress.Key())
+	}
+	return keys
+}
+
+func TestFilterComposition(t *testing.T) {
+	infos := []CAddrInfo{
+		addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork|NodeWitness),
+		addrInfo("10.0.0.1", 8333, 1700000000, NodeNetwork|NodeWitness),
+		addrInfo("2001:4860::1", 8333, 1700000000, NodeNetwork),
+		addrInfo("5.6.7.8", 8333, 1600000000, NodeNetwork|NodeWitness),
+		addrInfo("9.9.9.9", 8333, 1700000000, NodeNetworkLimited),
+	}
+
+	for _, test := range []struct {
+		name  string
+		preds []Predicate
+		want  []string
+	}{
+		{"none", nil, hosts(infos)},
+		{"network", []Predicate{ByNetwork(NetworkIPv6)}, []string{"[2001:4860::1]:8333"}},
+		{"networks", []Predicate{ByNetwork(NetworkIPv6, NetworkTor)}, []string{"[2001:4860::1]:8333"}},
+		{"routable", []Predicate{Routable()}, []string{"1.2.3.4:8333", "[2001:4860::1]:8333", "5.6.7.8:8333", "9.9.9.9:8333"}},
+		{"and", []Predicate{ByNetwork(NetworkIPv4), ByServiceFlag(NodeWitness), NewerThan(1650000000), Routable()}, []string{"1.2.3.4:8333"}},
+		{"not", []Predicate{ByNetwork(NetworkIPv4), Not(ByServiceFlag(NodeWitness))}, []string{"9.9.9.9:8333"}},
+		{"all flags", []Predicate{ByServiceFlag(NodeNetwork | NodeWitness)}, []string{"1.2.3.4:8333", "10.0.0.1:8333", "5.6.7.8:8333"}},
+		{"nothing", []Predicate{ByNetwork(NetworkI2P), Routable()}, nil},
+	} {
+		got := hosts(Filter(infos, test.preds...))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+				break
+			}
+		}
+	}
+
+	// composing filters keeps the input as it was
+	Filter(infos, ByNetwork(NetworkIPv6))
+	if len(infos) != 5 || infos[0].Address.PeerAddress.Key() != "1.2.3.4:8333" {
+		t.Error("Filter modified its input")
+	}
+}
+
+func TestFilterByService(t *testing.T) {
+	peersDB := PeersDB{
+		NewAddrInfo: []CAddrInfo{
+			addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork|NodeWitness),
+			addrInfo("5.6.7.8", 8333, 1700000000, NodeNetwork),
+			addrInfo("9.9.9.9", 8333, 1700000000, NodeWitness|NodeCompactFilters),
+		},
+		TriedAddrInfo: []CAddrInfo{
+			addrInfo("3.3.3.3", 8333, 1700000000, NodeNetworkLimited),
+			addrInfo("4.4.4.4", 8333, 1700000000, NodeNetwork|NodeWitness|NodeCompactFilters),
+		},
+	}
+	for flag, want := range map[uint64][]string{
+		NodeWitness:                      {"1.2.3.4:8333", "9.9.9.9:8333", "4.4.4.4:8333"},
+		NodeCompactFilters:               {"9.9.9.9:8333", "4.4.4.4:8333"},
+		NodeNetwork | NodeCompactFilters: {"4.4.4.4:8333"},
+		NodeBloom:                        nil,
+	} {
+		got := hosts(peersDB.FilterByService(flag))
+		if len(got) != len(want) {
+			t.Errorf("%s: got %q, want %q", ServiceNames(flag), got, want)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %q, want %q", ServiceNames(flag), got, want)
+				break
+			}
+		}
+	}
+
+	// the witness bit read from a file's little endian services
+	parsed := parseFixture(t, mainnetFixture())
+	for _, info := range parsed.FilterByService(NodeWitness) {
+		if info.Address.Services()&NodeWitness == 0 {
+			t.Errorf("%s doesn't advertise NODE_WITNESS", info.Address.PeerAddress)
+		}
+	}
+	if got := len(parsed.FilterByService(NodeCompactFilters)); got != 1 {
+		t.Errorf("got %d compact filter peers, want 1", got)
+	}
+}
+
+func TestHasValidPort(t *testing.T) {
+	table := []CAddrInfo{
+		addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork),
+		addrInfo("5.6.7.8", 0, 1700000000, NodeNetwork),
+	}
+	if !HasValidPort(table[0]) || HasValidPort(table[1]) {
+		t.Errorf("HasValidPort gave %t for port 8333 and %t for port 0", HasValidPort(table[0]), HasValidPort(table[1]))
+	}
+
+	// as -drop-invalid-ports does, the port 0 entry is left out of the
+	// stats even though its host is reachable
+	newResult, _, err := ComputeStats(writeBitnodes(t, "1.2.3.4", "5.6.7.8"), 1700000000, Filter(table, HasValidPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newResult.TotalIPs != 1 || newResult.NumberOfReachableIPs != 1 || newResult.ReachableIPs[0] != "1.2.3.4" {
+		t.Errorf("got %d of %d reachable %q, want only 1.2.3.4", newResult.NumberOfReachableIPs, newResult.TotalIPs, newResult.ReachableIPs)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serialization versions of hand built entries: a pre addrv2 client's, and
+// Core's PROTOCOL_VERSION 70016 flagged as addrv2 as it writes since 0.21
+const (
+	legacySerializationVersion = 0x2ef61
+	addrV2SerializationVersion = 70016 | addrV2Format
+)
+
+var mainnetMagic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
+
+// fixtureEntry is a table entry of a hand built peers.dat
+type fixtureEntry struct {
+	ip          string
+	port        uint16
+	time        uint32
+	services    uint64
+	source      string
+	lastSuccess uint64
+	attempts    uint32
+}
+
+// legacy encodes the entry in the original 16 byte address format
+func (e fixtureEntry) legacy() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(legacySerializationVersion))
+	binary.Write(&b, binary.LittleEndian, e.time)
+	binary.Write(&b, binary.LittleEndian, e.services)
+	b.Write(net.ParseIP(e.ip).To16())
+	binary.Write(&b, binary.BigEndian, e.port)
+	b.Write(net.ParseIP(e.source).To16())
+	binary.Write(&b, binary.LittleEndian, e.lastSuccess)
+	binary.Write(&b, binary.LittleEndian, e.attempts)
+	return b.Bytes()
+}
+
+// addrV2Entry encodes an entry in the addrv2 format with an IPv4 source
+func addrV2Entry(network BIP155Network, addr []byte, port uint16, time uint32, services uint64, source string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(addrV2SerializationVersion))
+	binary.Write(&b, binary.LittleEndian, time)
+	writeCompactSize(&b, services)
+	b.WriteByte(byte(network))
+	writeCompactSize(&b, uint64(len(addr)))
+	b.Write(addr)
+	binary.Write(&b, binary.BigEndian, port)
+	b.WriteByte(byte(BIP155IPv4))
+	b.WriteByte(4)
+	b.Write(net.ParseIP(source).To4())
+	binary.Write(&b, binary.LittleEndian, uint64(0))
+	binary.Write(&b, binary.LittleEndian, uint32(1))
+	return b.Bytes()
+}
+
+// fixtureFile is a peers.dat built byte by byte, independently of Serialize
+type fixtureFile struct {
+	magic      []byte
+	version    uint8
+	compatible uint8
+	new        [][]byte
+	tried      [][]byte
+	// layout replaces the default of newBucketCount buckets with new entry
+	// i in bucket i
+	layout []byte
+}
+
+func (f fixtureFile) bytes() []byte {
+	var b bytes.Buffer
+	magic := f.magic
+	if magic == nil {
+		magic = mainnetMagic
+	}
+	b.Write(magic)
+	b.WriteByte(f.version)
+	b.WriteByte(incompatibilityBase + f.compatible)
+	for i := 0; i < 32; i++ {
+		b.WriteByte(byte(i))
+	}
+	binary.Write(&b, binary.LittleEndian, uint32(len(f.new)))
+	binary.Write(&b, binary.LittleEndian, uint32(len(f.tried)))
+	buckets := uint32(newBucketCount)
+	if f.version >= FormatDeterministic {
+		buckets ^= 1 << 30
+	}
+	binary.Write(&b, binary.LittleEndian, buckets)
+	for _, entry := range append(append([][]byte{}, f.new...), f.tried...) {
+		b.Write(entry)
+	}
+
+	if f.layout != nil {
+		b.Write(f.layout)
+	} else {
+		for bucket := 0; bucket < newBucketCount; bucket++ {
+			if bucket < len(f.new) {
+				binary.Write(&b, binary.LittleEndian, uint32(1))
+				binary.Write(&b, binary.LittleEndian, uint32(bucket))
+			} else {
+				binary.Write(&b, binary.LittleEndian, uint32(0))
+			}
+		}
+	}
+	if f.version >= FormatASMap {
+		b.Write(make([]byte, 32))
+	}
+
+	checksum := doubleSHA256(b.Bytes())
+	b.Write(checksum[:])
+	return b.Bytes()
+}
+
+// legacyFixture builds a format 1 file of count new and count/4 tried
+// legacy IPv4 entries, an hour apart going back from now
+func legacyFixture(count int, now uint32) fixtureFile {
+	file := fixtureFile{version: FormatDeterministic}
+	for i := 0; i < count; i++ {
+		entry := fixtureEntry{
+			ip:       net.IPv4(1, byte(i>>16), byte(i>>8), byte(i)).String(),
+			port:     8333,
+			time:     now - uint32(i)*3600,
+			services: NodeNetwork | NodeWitness,
+			source:   "5.5.5.5",
+			attempts: uint32(i % 4),
+		}
+		file.new = append(file.new, entry.legacy())
+		if i%4 == 0 {
+			entry.ip = net.IPv4(2, byte(i>>16), byte(i>>8), byte(i)).String()
+			entry.lastSuccess = uint64(entry.time)
+			file.tried = append(file.tried, entry.legacy())
+		}
+	}
+	if count > newBucketCount {
+		file.layout = spreadLayout(count)
+	}
+	return file
+}
+
+// spreadLayout places count new entries round robin over newBucketCount
+// buckets
+func spreadLayout(count int) []byte {
+	var b bytes.Buffer
+	for bucket := 0; bucket < newBucketCount; bucket++ {
+		var indexes []uint32
+		for i := bucket; i < count; i += newBucketCount {
+			indexes = append(indexes, uint32(i))
+		}
+		binary.Write(&b, binary.LittleEndian, uint32(len(indexes)))
+		binary.Write(&b, binary.LittleEndian, indexes)
+	}
+	return b.Bytes()
+}
+
+// writeFixture writes data to a file in a temporary directory, returning
+// its path
+func writeFixture(tb testing.TB, name string, data []byte) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// parseFixture writes the file and parses it with NewPeersDB
+func parseFixture(tb testing.TB, file fixtureFile) PeersDB {
+	tb.Helper()
+	peersDB, err := NewPeersDB(writeFixture(tb, "peers.dat", file.bytes()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return peersDB
+}
+
+// addrInfo returns an entry as the parser reads a legacy one, for tests
+// that don't go through a file
+func addrInfo(ip string, port uint16, time uint32, services uint64) CAddrInfo {
+	info := CAddrInfo{
+		Address: CAddress{
+			SerializationVersion: binary.LittleEndian.AppendUint32(nil, legacySerializationVersion),
+			Time:                 time,
+			ServiceFlags:         binary.BigEndian.AppendUint64(nil, services),
+			PeerAddress:          CService{IPAddress: net.ParseIP(ip).To16(), Port: port},
+		},
+		Source:         net.ParseIP("5.5.5.5").To16(),
+		BucketIndex:    -1,
+		BucketPosition: -1,
+	}
+	return info
+}
+
+// writeBitnodes writes a bitnode snapshot of one host per line, returning
+// its path
+func writeBitnodes(tb testing.TB, hosts ...string) string {
+	tb.Helper()
+	var b bytes.Buffer
+	for _, host := range hosts {
+		b.WriteString(host + "\n")
+	}
+	return writeFixture(tb, "1700000000.txt", b.Bytes())
+}
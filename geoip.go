@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeoIPDB maps IP addresses to countries from a range database in the CSV
+// layout of the free DB-IP and IP2Location country files: one
+// start,end,country line per range, IPv4 and IPv6 alike
+type GeoIPDB struct {
+	ranges []geoRange
+}
+
+type geoRange struct {
+	start   net.IP // 16 byte form
+	end     net.IP
+	country string
+}
+
+// LoadGeoIPCSV reads a CSV range database. Fields may be quoted and extra
+// fields after the country are ignored.
+func LoadGeoIPCSV(path string) (*GeoIPDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read GeoIP database %s", path)
+	}
+	defer file.Close()
+
+	var db GeoIPDB
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("Invalid range on line %d of GeoIP database %s", line, path)
+		}
+		db.ranges = append(db.ranges, geoRange{start.To16(), end.To16(), strings.ToUpper(fields[2])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't scan GeoIP database %s: %s", path, err)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return &db, nil
+}
+
+// Country returns the country code of ip, ok being false if no range
+// contains it
+func (db *GeoIPDB) Country(ip net.IP) (country string, ok bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return "", false
+	}
+	// the last range starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return "", false
+	}
+	return db.ranges[i].country, true
+}
+
+// CountryCount is the number of a table's addresses located in a country,
+// and of those the number found reachable
+type CountryCount struct {
+	Country   string
+	Tabled    int
+	Reachable int
+}
+
+// CountCountries locates the table's IP addresses, onion and other non-IP
+// entries having no country, and counts them per country along with the
+// reachable ones of result. Countries are ordered by tabled count, most
+// first.
+func CountCountries(db *GeoIPDB, table []CAddrInfo, result *Result) []CountryCount {
+	reachable := make(map[string]bool, len(result.ReachableIPs))
+	for _, ip := range result.ReachableIPs {
+		reachable[ip] = true
+	}
+
+	counts := make(map[string]*CountryCount)
+	for _, info := range table {
+		if _, err := info.TCPAddr(); err != nil {
+			continue
+		}
+		country, ok := db.Country(info.Address.PeerAddress.IPAddress)
+		if !ok {
+			continue
+		}
+		count, seen := counts[country]
+		if !seen {
+			count = &CountryCount{Country: country}
+			counts[country] = count
+		}
+		count.Tabled++
+		if reachable[reachabilityKey(info.Address.PeerAddress)] {
+			count.Reachable++
+		}
+	}
+
+	countries := make([]CountryCount, 0, len(counts))
+	for _, count := range counts {
+		countries = append(countries, *count)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		if countries[i].Tabled != countries[j].Tabled {
+			return countries[i].Tabled > countries[j].Tabled
+		}
+		return countries[i].Country < countries[j].Country
+	})
+	return countries
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+// EncodeGob writes the parsed peers database as a gob stream, so that it can
+// be cached and reloaded without parsing peers.dat again
+func EncodeGob(db PeersDB, w io.Writer) error {
+	return gob.NewEncoder(w).Encode(db)
+}
+
+// DecodeGob reads a peers database written by EncodeGob. The Path is that of
+// the peers.dat originally parsed.
+func DecodeGob(r io.Reader) (PeersDB, error) {
+	var db PeersDB
+	err := gob.NewDecoder(r).Decode(&db)
+	return db, err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestGobRoundTrip(t *testing.T) {
+	for name, file := range map[string]fixtureFile{"legacy": legacyFixture(20, 1700000000), "addrv2": mainnetFixture()} {
+		t.Run(name, func(t *testing.T) {
+			peersDB := parseFixture(t, file)
+
+			var buf bytes.Buffer
+			if err := EncodeGob(peersDB, &buf); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeGob(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.Path != peersDB.Path || !bytes.Equal(decoded.MessageBytes, peersDB.MessageBytes) || decoded.Version != peersDB.Version ||
+				decoded.KeySize != peersDB.KeySize || !bytes.Equal(decoded.NKey, peersDB.NKey) || decoded.NNew != peersDB.NNew ||
+				decoded.NTried != peersDB.NTried || decoded.NewBuckets != peersDB.NewBuckets {
+				t.Error("decoded header differs")
+			}
+			if !reflect.DeepEqual(decoded.NewAddrInfo, peersDB.NewAddrInfo) || !reflect.DeepEqual(decoded.TriedAddrInfo, peersDB.TriedAddrInfo) {
+				t.Error("decoded tables differ")
+			}
+		})
+	}
+
+	if _, err := DecodeGob(bytes.NewReader([]byte("not a gob"))); err == nil {
+		t.Error("decoded garbage without an error")
+	}
+}
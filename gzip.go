@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// the two bytes every gzip stream starts with
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// gunzip decompresses a whole gzip stream
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gzip decompression failed: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gzip decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
+// NewPeersDBGzip parses a gzip compressed peers file. NewPeersDB detects
+// compressed files by their magic bytes and handles them too; this fails
+// instead on a file that isn't compressed.
+func NewPeersDBGzip(path string) (PeersDB, error) {
+	peersDB := PeersDB{
+		Path: path,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return peersDB, fmt.Errorf("Couldn't read peer file %s: %w", path, err)
+	}
+	if !isGzip(data) {
+		return peersDB, fmt.Errorf("Peer file %s is not gzip compressed", path)
+	}
+	dbbytes, err := gunzip(data)
+	if err != nil {
+		return peersDB, fmt.Errorf("Couldn't read peer file %s: %w", path, err)
+	}
+	return parsePeersDB(peersDB, dbbytes)
+}
@@ -0,0 +1,145 @@
+package main
+
+// JSONSchemaVersion versions the JSON documents peer_stats writes. It is
+// bumped whenever a field is removed, renamed or changes meaning; adding a
+// field is not a breaking change and doesn't bump it. Fields are written in
+// the order they are declared in below.
+const JSONSchemaVersion = 1
+
+// ResultDocument is the JSON document written for a table's result
+type ResultDocument struct {
+	SchemaVersion int         `json:"schema_version"`
+	Label         string      `json:"label,omitempty"`
+	Table         string      `json:"table"`
+	Stats         ResultStats `json:"stats"`
+}
+
+// StatsDocument is the JSON document holding the results of both tables of
+// a node, written by the json-combined output format
+type StatsDocument struct {
+	SchemaVersion int         `json:"schema_version"`
+	Label         string      `json:"label,omitempty"`
+	ApproxAge     uint32      `json:"approx_age"`
+	ApproxAgeTime string      `json:"approx_age_time"`
+	New           ResultStats `json:"new"`
+	Tried         ResultStats `json:"tried"`
+}
+
+// ResultStats is the JSON form of a Result. Times are unix epochs with an
+// RFC3339 UTC rendering alongside, percentages are fractions from 0 to 1
+// rounded to -percent-precision decimal places as percentages.
+// The oldest and newest entry fields are null for an empty table.
+type ResultStats struct {
+	ApproxAge           uint32        `json:"approx_age"`
+	ApproxAgeTime       string        `json:"approx_age_time"`
+	Reference           uint32        `json:"reference"`
+	TotalIPs            int           `json:"total_ips"`
+	ReachableCount      int           `json:"reachable_count"`
+	Percentage          float64       `json:"percentage"`
+	NoCrawlData         bool          `json:"no_crawl_data"`
+	SnapshotNetworkSize int           `json:"snapshot_network_size"`
+	OldestIP            *uint32       `json:"oldest_ip"`
+	OldestIPDays        *int          `json:"oldest_ip_days"`
+	NewestIP            *uint32       `json:"newest_ip"`
+	SpanDays            *float64      `json:"span_days"`
+	AgeBuckets          []BucketJSON  `json:"age_buckets"`
+	LastSuccessBuckets  []BucketJSON  `json:"last_success_buckets"`
+	NeverSucceeded      int           `json:"never_succeeded"`
+	Terrible            int           `json:"terrible"`
+	P2PV2Count          int           `json:"p2p_v2_count"`
+	Services            []ServiceJSON `json:"services"`
+	Networks            []NetworkJSON `json:"networks"`
+	Warnings            []string      `json:"warnings"`
+	ReachableIPs        []string      `json:"reachable_ips"`
+}
+
+// BucketJSON is an age bucket, bounds in seconds and max 0 for the oldest,
+// open ended one
+type BucketJSON struct {
+	Label string `json:"label"`
+	Min   uint32 `json:"min"`
+	Max   uint32 `json:"max"`
+	Count int    `json:"count"`
+}
+
+// ServiceJSON is the reachability of the entries advertising a service
+type ServiceJSON struct {
+	Flag      uint64 `json:"flag"`
+	Name      string `json:"name"`
+	Total     int    `json:"total"`
+	Reachable int    `json:"reachable"`
+}
+
+// NetworkJSON is the reachability of a network's entries. Crawled is false
+// when the bitnode snapshot had no host of the network to match against.
+type NetworkJSON struct {
+	Network   string `json:"network"`
+	Total     int    `json:"total"`
+	Reachable int    `json:"reachable"`
+	Crawled   bool   `json:"crawled"`
+}
+
+// NewResultDocument builds the JSON document of a table's result
+func NewResultDocument(table string, result *Result) ResultDocument {
+	return ResultDocument{
+		SchemaVersion: JSONSchemaVersion,
+		Label:         result.Label,
+		Table:         table,
+		Stats:         newResultStats(result),
+	}
+}
+
+// NewStatsDocument builds the JSON document of both tables' results, which
+// share their label and approx age
+func NewStatsDocument(newResult, triedResult *Result) StatsDocument {
+	return StatsDocument{
+		SchemaVersion: JSONSchemaVersion,
+		Label:         newResult.Label,
+		ApproxAge:     newResult.ApproxAge,
+		ApproxAgeTime: isoTime(newResult.ApproxAge),
+		New:           newResultStats(newResult),
+		Tried:         newResultStats(triedResult),
+	}
+}
+
+func newResultStats(result *Result) ResultStats {
+	stats := ResultStats{
+		ApproxAge:           result.ApproxAge,
+		ApproxAgeTime:       isoTime(result.ApproxAge),
+		Reference:           result.Reference,
+		TotalIPs:            result.TotalIPs,
+		ReachableCount:      result.NumberOfReachableIPs,
+		Percentage:          roundPercent(result.Percentage),
+		NoCrawlData:         result.NoCrawlData,
+		SnapshotNetworkSize: result.SnapshotNetworkSize,
+		AgeBuckets:          bucketsJSON(result.Age),
+		LastSuccessBuckets:  bucketsJSON(result.LastSuccessAge),
+		NeverSucceeded:      result.NeverSucceeded,
+		Terrible:            result.Terrible,
+		P2PV2Count:          result.P2PV2Count,
+		Services:            []ServiceJSON{},
+		Networks:            []NetworkJSON{},
+		Warnings:            append([]string{}, result.Warnings...),
+		ReachableIPs:        append([]string{}, result.ReachableIPs...),
+	}
+	if result.TotalIPs > 0 {
+		oldestIPDays := (int(result.Reference) - int(result.OldestTime)) / ONE_DAY
+		stats.OldestIP, stats.NewestIP = &result.OldestTime, &result.NewestTime
+		stats.OldestIPDays, stats.SpanDays = &oldestIPDays, &result.SpanDays
+	}
+	for _, service := range result.Services {
+		stats.Services = append(stats.Services, ServiceJSON{service.Flag, ServiceName(service.Flag), service.Total, service.Reachable})
+	}
+	for _, network := range result.Networks {
+		stats.Networks = append(stats.Networks, NetworkJSON{network.Network.String(), network.Total, network.Reachable, network.Crawled})
+	}
+	return stats
+}
+
+func bucketsJSON(ageBuckets AgeBuckets) []BucketJSON {
+	var buckets []BucketJSON
+	for _, bucket := range ageBuckets.Buckets() {
+		buckets = append(buckets, BucketJSON(bucket))
+	}
+	return buckets
+}
@@ -1,55 +1,307 @@
 package main
 
-// USAGE: ./peer_stats ./node1/ /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt
+// USAGE: ./peer_stats [flags] -peers ./node1/ -bitnodes-dir /data/bitnodes/stripped/ -timestamps /data/bitnodes/timestamps.txt [-out ./stats/]
+//        ./peer_stats [flags] ./node1/ /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt
+//        ./peer_stats [flags] ./node1/peers.dat.bak /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt
+//        ./peer_stats [flags] batch /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt ./node1/ node2=./node2/ ...
+//        ./peer_stats jaccard ./node1/peers.dat ./node2/peers.dat
+//        ./peer_stats anchors ./node1/anchors.dat
+//        ./peer_stats inspect ./node1/ --table tried --index 42
 
 import (
     "bufio"
+    "context"
+    "encoding/hex"
+    "flag"
     "fmt"
+    "io"
+    "log"
+    "net"
     "os"
-    "strconv"
+    "path/filepath"
+    "regexp"
+    "sort"
     "strings"
-    "time"
+    "strconv"
 )
 
-// AgeBuckets holds count of age buckets
+var compressOutput bool
+var quiet bool
+var xorKey string
+var timeFormat string
+var dropInvalidPorts bool
+var dropInternal bool
+var sourceNetworkMismatch bool
+var extremesCount int
+var resume bool
+var networksSummary bool
+var compareNetworks bool
+var seedOut string
+var batchMode bool // set by runBatch, for options written per node
+var lenient bool
+var cumulative bool
+var networkScope string
+var maxAgeDays int
+var diffDatadir bool
+var outputFormat string
+var validate bool
+var prettyJSON bool
+var onlyReachable bool
+var percentPrecision = 2
+var dailyOut string
+var manifestPath string
+var includeRegex string
+var excludeRegex string
+var nodeLabel string
+var snapshotCacheSize int
+var geoIPPath string
+var referenceTime string
+var topCountries int
+var ageBucketDays string
+var approxAgeStrategy ApproxAgeStrategy
+var peersArg string
+var bitnodesDir string
+var timestampsPath string
+var outDir string
+var approxAgeName string
+
+// logger prints diagnostics to stderr unless -quiet is given, keeping them
+// apart from the data written to stdout and the output files
+var logger = log.New(os.Stderr, "", 0)
+
+func init() {
+    flag.BoolVar(&compressOutput, "compress", false, "gzip the output files and append .gz to their names")
+    flag.BoolVar(&quiet, "quiet", false, "do not print diagnostics or the summary report")
+    flag.StringVar(&timeFormat, "time-format", "Jan 2 2006", "Go time layout for the Approx_Peerdat_Date column")
+    flag.BoolVar(&dropInvalidPorts, "drop-invalid-ports", false, "exclude entries with port 0 from the stats")
+    flag.BoolVar(&MappedAsIPv6, "ipv4-mapped-as-ipv6", false, "classify IPv4-mapped addresses of legacy entries as ipv6; by default they are ipv4, as in Core")
+    flag.BoolVar(&StrictReachability, "strict-reachability", false, "match addresses against the bitnode file by host and port rather than host alone")
+    flag.BoolVar(&NormalizeOnionNames, "normalize-onion", false, "canonicalize onion names, lowercased with the .onion suffix, before matching")
+    flag.BoolVar(&dropInternal, "drop-internal", false, "exclude Core's internal addresses, which aren't real peers, from the stats and exports")
+    flag.BoolVar(&sourceNetworkMismatch, "source-network-mismatch", false, "report source network against address network")
+    flag.IntVar(&extremesCount, "oldest", 0, "print the `N` oldest and newest entries of each table")
+    flag.BoolVar(&resume, "resume", false, "in batch mode, skip nodes already processed and unchanged since")
+    flag.StringVar(&manifestPath, "manifest", "peer_stats.manifest.json", "the batch mode progress manifest")
+    flag.BoolVar(&networksSummary, "networks-summary", false, "also write per-network counts to networks-summary.json")
+    flag.BoolVar(&compareNetworks, "compare-networks", false, "print the per-network composition of the new and tried tables side by side")
+    flag.IntVar(&MaxBitnodeLineSize, "max-line-size", MaxBitnodeLineSize, "the longest line accepted in the bitnode file, in bytes")
+    flag.StringVar(&seedOut, "seed-out", "", "write reachable addresses, freshest first, as host:port lines to `path`; in batch mode to its base name in each node's output directory")
+    flag.BoolVar(&lenient, "lenient", false, "skip unparseable records in a damaged peers.dat instead of failing")
+    flag.BoolVar(&cumulative, "cumulative", false, "add the age distribution as percentages and cumulative percentages")
+    flag.StringVar(&networkScope, "network", "", "restrict the stats and the bitnode file to one network {ipv4|ipv6|onion}")
+    flag.IntVar(&maxAgeDays, "max-age-days", 0, "drop entries more than this many days older than the approx age before computing stats")
+    flag.BoolVar(&diffDatadir, "diff-against-datadir", false, "cross-reference peers.dat with anchors.dat and banlist.json in the node directory")
+    flag.StringVar(&outputFormat, "format", "csv", "comma separated output formats {csv|tsv|json|json-combined}, written from the same parse; json-combined puts both tables in table-stats.json")
+    flag.BoolVar(&validate, "validate", false, "report entries that look corrupt")
+    flag.BoolVar(&prettyJSON, "pretty", false, "indent JSON output")
+    flag.BoolVar(&onlyReachable, "only-reachable", false, "also write the reachable entries of each table with their full metadata")
+    flag.IntVar(&percentPrecision, "percent-precision", 2, "decimal places of the percentages in every output format")
+    flag.StringVar(&dailyOut, "daily-out", "", "in batch mode, write per-day rollups of the processed nodes into `dir`")
+    flag.StringVar(&includeRegex, "include-regex", "", "only keep entries whose normalized host matches `regexp`")
+    flag.StringVar(&excludeRegex, "exclude-regex", "", "drop entries whose normalized host matches `regexp`, even if -include-regex matches")
+    flag.StringVar(&nodeLabel, "label", "", "tag the output rows with this node id; batch mode always tags them, with the directory name unless a node is given as label=path")
+    flag.IntVar(&snapshotCacheSize, "snapshot-cache", 8, "keep the hosts of up to `N` bitnode snapshots in memory for reuse across nodes, 0 to disable")
+    flag.StringVar(&geoIPPath, "geoip", "", "a start,end,country CSV range database to locate addresses with")
+    flag.IntVar(&topCountries, "top-countries", 0, "print the `N` countries with the most tabled and reachable addresses, needs -geoip")
+    flag.BoolVar(&RetainBuckets, "retain-bucket-info", false, "keep the new table's bucket layout and write it to new-table-buckets.txt")
+    flag.StringVar(&referenceTime, "reference", "approx", "measure ages from {approx|now|unix epoch}; now suits a live node, on an archived file it ages every entry")
+    flag.StringVar(&xorKey, "xor-key", "", "hex xor key to deobfuscate peers.dat with before parsing")
+    flag.StringVar(&ageBucketDays, "age-buckets", "1,5,10,30", "comma separated upper bounds of the age buckets in days, youngest first")
+    flag.StringVar(&peersArg, "peers", "", "the node directory or peers.dat file to compute stats for, instead of the first argument")
+    flag.StringVar(&bitnodesDir, "bitnodes-dir", "", "the directory of bitnode snapshots, instead of the second argument")
+    flag.StringVar(&timestampsPath, "timestamps", "", "the file listing the snapshot timestamps, instead of the third argument")
+    flag.StringVar(&outDir, "out", "", "write the output files into `dir` rather than next to peers.dat; in batch mode into a subdirectory per node")
+    flag.StringVar(&approxAgeName, "approx-age", "max", "estimate the file's save time from the entries' timestamps by {max|median|p95}; median resists bogus future timestamps")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "USAGE: ./peer_stats [flags] -peers ./node1/ -bitnodes-dir /data/bitnodes/stripped/ -timestamps /data/bitnodes/timestamps.txt")
+        fmt.Fprintln(os.Stderr, "       ./peer_stats [flags] ./node1/ /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt")
+        fmt.Fprintln(os.Stderr, "       ./peer_stats [flags] {batch|jaccard|anchors|inspect} ...")
+        flag.PrintDefaults()
+    }
+}
+
+// parseFlags parses the command line and sets up the options derived from
+// the flags
+func parseFlags() {
+    flag.Parse()
+
+    if quiet {
+        logger.SetOutput(io.Discard)
+    }
+    snapshotCache = NewSnapshotCache(snapshotCacheSize)
+
+    switch referenceTime {
+    case "approx":
+        StatsReference = ReferenceApproxAge
+    case "now":
+        StatsReference = ReferenceNow(SystemClock)
+    default:
+        ts, err := strconv.ParseUint(referenceTime, 10, 32)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid reference %s\n", referenceTime)
+            os.Exit(1)
+        }
+        StatsReference = ReferenceFixed(uint32(ts))
+    }
+
+    boundaries, err := ParseAgeBoundaries(ageBucketDays)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    AgeBoundaries = boundaries
+
+    approxAgeStrategy, err = ParseApproxAgeStrategy(approxAgeName)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+// AgeBuckets holds count of age buckets. Counts has one bucket per
+// boundary, each holding the ages below it, plus an open ended oldest
+// bucket.
 type AgeBuckets struct {
-    LessThanOne       int
-    OneToFive         int
-    FiveToTen         int
-    TenToThirty       int
-    GreaterThanThirty int
+    Boundaries []uint32 // upper bounds in seconds, youngest first
+    Counts     []int
 }
 
 // Result holds the result of computation
 type Result struct {
+    Label                string
+    ApproxAge            uint32
+    Reference            uint32 // the time ages are measured from
     NumberOfReachableIPs int
+    ReachableIPs         []string
     TotalIPs             int
-    Percentage           float64
+    Percentage           float64 // 0 for an empty table
     OldestIPAge          uint32
     Age                  AgeBuckets
+    NoCrawlData          bool
+    SnapshotNetworkSize  int
+    P2PV2Count           int
+    Services             []ServiceReachability
+    Networks             []NetworkReachability
+    LastSuccessAge       AgeBuckets
+    NeverSucceeded       int
+    Terrible             int
+    OldestTime           uint32
+    NewestTime           uint32
+    SpanDays             float64
+    Warnings             []string
+
+    entries []entryRecord
+}
+
+// NetworkCoverage returns the fraction of the reachable network, as counted
+// by the bitnode snapshot, that the table knows about
+func (result *Result) NetworkCoverage() float64 {
+    if result.SnapshotNetworkSize == 0 {
+        return 0
+    }
+    return float64(result.NumberOfReachableIPs) / float64(result.SnapshotNetworkSize)
+}
+
+// P2PV2Percentage returns the fraction of the table advertising BIP324 v2
+// transport
+func (result *Result) P2PV2Percentage() float64 {
+    if result.TotalIPs == 0 {
+        return 0
+    }
+    return float64(result.P2PV2Count) / float64(result.TotalIPs)
+}
+
+// reachableShare returns the fraction of total that is reachable, 0 rather
+// than NaN when the table is empty as the tried table of a freshly started
+// node is
+func reachableShare(reachable, total int) float64 {
+    if total == 0 {
+        return 0
+    }
+    return float64(reachable) / float64(total)
+}
+
+// checkPercentage asserts that no more IPs are reachable than exist in the
+// table, capping the result at 100% and recording a warning if they are
+func (result *Result) checkPercentage(table string) {
+    if result.NumberOfReachableIPs <= result.TotalIPs {
+        return
+    }
+
+    result.Warnings = append(result.Warnings, fmt.Sprintf("%s table: %d reachable IPs exceeds %d total, capping at 100%%", table, result.NumberOfReachableIPs, result.TotalIPs))
+    result.NumberOfReachableIPs = result.TotalIPs
+    result.Percentage = 1
 }
 
 // CreateResult returns new object
 func CreateResult() *Result {
     res := &Result{
-        Age: AgeBuckets{
-            LessThanOne:       0,
-            OneToFive:         0,
-            FiveToTen:         0,
-            TenToThirty:       0,
-            GreaterThanThirty: 0,
-        },
+        Age:            NewAgeBuckets(AgeBoundaries...),
+        LastSuccessAge: NewAgeBuckets(AgeBoundaries...),
     }
 
     return res
 }
 
+// MaxBitnodeLineSize is the longest line ComputeStats accepts in a bitnode
+// file. Longer lines, e.g. from a binary blob, make it return an error.
+var MaxBitnodeLineSize = 1024 * 1024
+
+// StrictReachability matches addresses against bitnode file lines by host
+// and port, for bitnode files listing ports, instead of by host alone
+var StrictReachability = false
+
+// reachabilityKey returns the key the address is matched against bitnode
+// file lines by, the normalized host without its port unless
+// StrictReachability is set
+func reachabilityKey(cService CService) string {
+    if StrictReachability {
+        return NormalizeEndpointKey(cService.Key())
+    }
+    return NormalizeHostKey(cService.Host())
+}
+
+// bitnodeKey returns the key of a bitnode file line, comparable with
+// reachabilityKey
+func bitnodeKey(line string) string {
+    if StrictReachability {
+        return NormalizeEndpointKey(line)
+    }
+    return NormalizeHostKey(line)
+}
+
 // ComputeStats computes the following stats
 // 1. oldest IP in each table
 // 2. Total reachable IPs in each table (at approximate age)
 // 3. Percentage of reachable IPs
 // 4. Agewise distribution of IPs
-func ComputeStats(bitnodeFilePath string, approxAge uint32, newTableIPs, triedTableIPs []CAddrInfo) (*Result, *Result) {
+func ComputeStats(bitnodeFilePath string, approxAge uint32, newTableIPs, triedTableIPs []CAddrInfo) (*Result, *Result, error) {
+    return ComputeStatsContext(context.Background(), bitnodeFilePath, approxAge, newTableIPs, triedTableIPs)
+}
+
+// ComputeStatsContext computes the stats of ComputeStats, giving up with
+// ctx.Err() once ctx is done. The bitnode file is checked against ctx every
+// few thousand lines while it is read and matched, so that a cancelled or
+// timed out run over a large crawl stops promptly.
+func ComputeStatsContext(ctx context.Context, bitnodeFilePath string, approxAge uint32, newTableIPs, triedTableIPs []CAddrInfo) (*Result, *Result, error) {
+    return computeStats(ctx, bitnodeFilePath, approxAge, newTableIPs, triedTableIPs, nil)
+}
+
+// ComputeStatsForNetwork computes the stats of ComputeStats for a single
+// network. Both the tabled entries and the lines of the bitnode file are
+// restricted to the network, so that e.g. onion reachability can be computed
+// against an onion-only crawl.
+func ComputeStatsForNetwork(bitnodeFilePath string, approxAge uint32, network Network, newTableIPs, triedTableIPs []CAddrInfo) (*Result, *Result, error) {
+    keepLine := func(line string) bool {
+        return HostNetwork(NormalizeHostKey(line)) == network
+    }
+    return computeStats(context.Background(), bitnodeFilePath, approxAge, Filter(newTableIPs, ByNetwork(network)), Filter(triedTableIPs, ByNetwork(network)), keepLine)
+}
+
+// how many bitnode file lines are processed between checks for cancellation
+const ctxCheckInterval = 4096
+
+func computeStats(ctx context.Context, bitnodeFilePath string, approxAge uint32, newTableIPs, triedTableIPs []CAddrInfo, keepLine func(string) bool) (*Result, *Result, error) {
     // initialize results object
     newResults := CreateResult()
     triedResults := CreateResult()
@@ -58,63 +310,123 @@ func ComputeStats(bitnodeFilePath string, approxAge uint32, newTableIPs, triedTa
     newSeenHashMap := make(map[string]bool)
     triedSeenHashMap := make(map[string]bool)
 
+    // ages are measured from the reference, the approx age unless
+    // StatsReference says otherwise
+    reference := StatsReference(approxAge)
+
     for i := 0; i < len(newTableIPs); i++ {
-        ip := newTableIPs[i].Address.PeerAddress.String()
-        // clip off the port number
-        newSeenHashMap[ip[:len(ip)-5]] = true
+        newSeenHashMap[reachabilityKey(newTableIPs[i].Address.PeerAddress)] = true
 
-        AddToAgeBucket(&newResults.Age, newTableIPs[i].Address.Time, approxAge)
+        AddToAgeBucket(&newResults.Age, newTableIPs[i].Address.Time, reference)
+        AddToLastSuccessBucket(newResults, newTableIPs[i], reference)
     }
 
     for i := 0; i < len(triedTableIPs); i++ {
-        ip := triedTableIPs[i].Address.PeerAddress.String()
-        // clip off the port number
-        triedSeenHashMap[ip[:len(ip)-5]] = true
+        triedSeenHashMap[reachabilityKey(triedTableIPs[i].Address.PeerAddress)] = true
 
-        AddToAgeBucket(&triedResults.Age, triedTableIPs[i].Address.Time, approxAge)
+        AddToAgeBucket(&triedResults.Age, triedTableIPs[i].Address.Time, reference)
+        AddToLastSuccessBucket(triedResults, triedTableIPs[i], reference)
     }
 
     // now checking if these IPs exist in the bitnode db
     var newReachableIPs []string
     var triedReachableIPs []string
 
-    // we read the bitnode file line by line and check if the address exists in our map
-    bitnodeFile, _ := os.Open(bitnodeFilePath)
-    scanner := bufio.NewScanner(bitnodeFile)
-    defer bitnodeFile.Close()
+    // we go through the hosts of the bitnode file and check if the address exists in our map
+    hosts, err := snapshotCache.Hosts(ctx, bitnodeFilePath)
+    if err != nil {
+        return nil, nil, err
+    }
 
     totalIPCount := 0
-    for scanner.Scan() {
-        ip := scanner.Text()
+    crawled := make(map[Network]bool)
+    for i, ip := range hosts {
+        if i%ctxCheckInterval == 0 {
+            if err := ctx.Err(); err != nil {
+                return nil, nil, err
+            }
+        }
+        if keepLine != nil && !keepLine(ip) {
+            continue
+        }
+        crawled[HostNetwork(NormalizeHostKey(ip))] = true
+        // each tabled address is matched at most once, so duplicate lines
+        // in the bitnode file can't inflate the reachable counts
         if _, found := newSeenHashMap[ip]; found {
             newReachableIPs = append(newReachableIPs, ip)
+            delete(newSeenHashMap, ip)
         }
         if _, found := triedSeenHashMap[ip]; found {
             triedReachableIPs = append(triedReachableIPs, ip)
+            delete(triedSeenHashMap, ip)
         }
         totalIPCount++
     }
 
+    newResults.SnapshotNetworkSize = totalIPCount
+    triedResults.SnapshotNetworkSize = totalIPCount
+
+    // an empty snapshot means the crawl failed, not that nothing is reachable
+    if totalIPCount == 0 {
+        newResults.NoCrawlData = true
+        triedResults.NoCrawlData = true
+    }
+
     // add other stats
+    newResults.ApproxAge = approxAge
+    triedResults.ApproxAge = approxAge
+    newResults.Reference = reference
+    triedResults.Reference = reference
+
+    newResults.ReachableIPs = newReachableIPs
+    triedResults.ReachableIPs = triedReachableIPs
+
     newResults.NumberOfReachableIPs = len(newReachableIPs)
     newResults.TotalIPs = len(newTableIPs)
-    newResults.Percentage = float64(len(newReachableIPs)) / float64(len(newTableIPs))
+    newResults.Percentage = reachableShare(len(newReachableIPs), len(newTableIPs))
 
     triedResults.NumberOfReachableIPs = len(triedReachableIPs)
     triedResults.TotalIPs = len(triedTableIPs)
-    triedResults.Percentage = float64(len(triedReachableIPs)) / float64(len(triedTableIPs))
+    triedResults.Percentage = reachableShare(len(triedReachableIPs), len(triedTableIPs))
+
+    newResults.recordEntries(newTableIPs, reference, newReachableIPs)
+    triedResults.recordEntries(triedTableIPs, reference, triedReachableIPs)
+
+    newResults.Terrible = CountTerrible(newTableIPs, reference)
+    triedResults.Terrible = CountTerrible(triedTableIPs, reference)
+
+    newResults.P2PV2Count = CountP2PV2(newTableIPs)
+    triedResults.P2PV2Count = CountP2PV2(triedTableIPs)
+
+    newResults.Services = ServiceBreakdown(newTableIPs, newReachableIPs)
+    triedResults.Services = ServiceBreakdown(triedTableIPs, triedReachableIPs)
+
+    newResults.Networks = NetworkBreakdown(newTableIPs, newReachableIPs, crawled)
+    triedResults.Networks = NetworkBreakdown(triedTableIPs, triedReachableIPs, crawled)
+
+    newResults.checkPercentage("new")
+    triedResults.checkPercentage("tried")
 
     // finally compute oldestIP in each table
     newResults.OldestIPAge = OldestIP(newTableIPs)
     triedResults.OldestIPAge = OldestIP(triedTableIPs)
 
-    return newResults, triedResults
+    newResults.setTimeSpan(newTableIPs)
+    triedResults.setTimeSpan(triedTableIPs)
+
+    return newResults, triedResults, nil
 
 }
 
 // ClosestBitnodeTS uses binary search to find the closest bitnode timestamp
-func ClosestBitnodeTS(tsFilePath string, approxAge uint32) uint32 {
-    tsFile, _ := os.Open(tsFilePath)
+// in the file listing them, one per line and in ascending order. Blank lines
+// are skipped; an unreadable file, a line that isn't a timestamp or a file
+// listing none is an error.
+func ClosestBitnodeTS(tsFilePath string, approxAge uint32) (uint32, error) {
+    tsFile, err := os.Open(tsFilePath)
+    if err != nil {
+        return 0, fmt.Errorf("Couldn't open timestamps file: %w", err)
+    }
     scanner := bufio.NewScanner(tsFile)
 
     defer tsFile.Close()
@@ -122,42 +434,58 @@ func ClosestBitnodeTS(tsFilePath string, approxAge uint32) uint32 {
     var tsArray []uint32
 
     // load timestamps into memory
-    for scanner.Scan() {
-        tsInt, _ := strconv.Atoi(scanner.Text())
-        tsArray = append(tsArray, uint32(tsInt))
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {
+            continue
+        }
+        ts, err := strconv.ParseUint(text, 10, 32)
+        if err != nil {
+            return 0, fmt.Errorf("Invalid timestamp %q on line %d of %s", text, line, tsFilePath)
+        }
+        tsArray = append(tsArray, uint32(ts))
+    }
+    if err := scanner.Err(); err != nil {
+        return 0, fmt.Errorf("Couldn't read %s: %w", tsFilePath, err)
+    }
+    if len(tsArray) == 0 {
+        return 0, fmt.Errorf("No timestamps in %s", tsFilePath)
     }
 
-    closest := BinSearch(0, len(tsArray), approxAge, tsArray)
-    return closest
+    closest, _ := BinSearch(approxAge, tsArray)
+    return closest, nil
 }
 
-// BinSearch modified binary search to find closest value in an array
-func BinSearch(low, high int, elem uint32, tsArray []uint32) uint32 {
-    mid := (high + low) / 2
-    if tsArray[mid] == elem {
-        // found element
-        return elem
+// BinSearch finds the value closest to elem in the sorted tsArray along with
+// its index. A tie between two neighbours goes to the later one. An empty
+// array has no closest value, giving 0 and an index of -1.
+func BinSearch(elem uint32, tsArray []uint32) (uint32, int) {
+    if len(tsArray) == 0 {
+        return 0, -1
     }
 
-    if mid == low {
-        // we have reached the end, return closest element
-        if elem-tsArray[low] < tsArray[high]-elem {
-            return tsArray[low]
+    // narrow down to the neighbours either side of elem
+    low, high := 0, len(tsArray)-1
+    for high-low > 1 {
+        mid := low + (high-low)/2
+        if tsArray[mid] == elem {
+            return elem, mid
+        }
+        if elem < tsArray[mid] {
+            high = mid
         } else {
-            return tsArray[high]
+            low = mid
         }
     }
 
-    // continue with the search
-    if elem < tsArray[mid] {
-        // check left half
-        newHigh := mid
-        return BinSearch(low, newHigh, elem, tsArray)
-    } else {
-        // check right half
-        newLow := mid
-        return BinSearch(newLow, high, elem, tsArray)
+    // elem may lie outside the array, or between low and high
+    if elem <= tsArray[low] {
+        return tsArray[low], low
     }
+    if elem >= tsArray[high] || tsArray[high]-elem <= elem-tsArray[low] {
+        return tsArray[high], high
+    }
+    return tsArray[low], low
 }
 
 // OldestIP returns the timestamp of the oldest
@@ -173,24 +501,96 @@ func OldestIP(table []CAddrInfo) uint32 {
     return oldestIP
 }
 
-// ApproxAge guesses approx time when peers.dat was saved
-func ApproxAge(peersDb PeersDB) uint32 {
-    var approxAge uint32 = 0 // suffiently large number
-    var i uint32
-    for i = 0; i < peersDb.NNew; i++ {
-        if approxAge < peersDb.NewAddrInfo[i].Address.Time {
-            approxAge = peersDb.NewAddrInfo[i].Address.Time
+// TimeSpan returns the oldest and newest address timestamps of the table in
+// one pass, both 0 for an empty table
+func TimeSpan(table []CAddrInfo) (oldest, newest uint32) {
+    for i, info := range table {
+        if i == 0 || info.Address.Time < oldest {
+            oldest = info.Address.Time
+        }
+        if info.Address.Time > newest {
+            newest = info.Address.Time
         }
     }
+    return oldest, newest
+}
+
+// setTimeSpan fills in the temporal extent of the table
+func (result *Result) setTimeSpan(table []CAddrInfo) {
+    result.OldestTime, result.NewestTime = TimeSpan(table)
+    result.SpanDays = float64(result.NewestTime-result.OldestTime) / ONE_DAY
+}
+
+// ApproxAgeStrategy picks how ApproxAge estimates when peers.dat was saved
+// from the entries' timestamps
+type ApproxAgeStrategy uint8
 
-    for i = 0; i < peersDb.NTried; i++ {
-        if approxAge < peersDb.TriedAddrInfo[i].Address.Time {
-            approxAge = peersDb.TriedAddrInfo[i].Address.Time
+const (
+    // ApproxAgeMax takes the newest timestamp. A single peer advertising a
+    // time in the future skews it, by as much as that peer's clock is off.
+    ApproxAgeMax ApproxAgeStrategy = iota
+    // ApproxAgeMedian takes the median timestamp, which a few bogus future
+    // timestamps can't move. It is recommended when the file may contain
+    // them, but lands well before the save time, by about half the table's
+    // age spread.
+    ApproxAgeMedian
+    // ApproxAgePercentile95 takes the 95th percentile, a middle ground
+    // tolerating up to 5% bogus timestamps
+    ApproxAgePercentile95
+)
+
+var approxAgeStrategyNames = map[ApproxAgeStrategy]string{
+    ApproxAgeMax:          "max",
+    ApproxAgeMedian:       "median",
+    ApproxAgePercentile95: "p95",
+}
+
+func (strategy ApproxAgeStrategy) String() string {
+    return approxAgeStrategyNames[strategy]
+}
+
+// ParseApproxAgeStrategy returns the strategy with the given name
+func ParseApproxAgeStrategy(name string) (ApproxAgeStrategy, error) {
+    for strategy, strategyName := range approxAgeStrategyNames {
+        if name == strategyName {
+            return strategy, nil
         }
     }
+    return 0, fmt.Errorf("Invalid approx age strategy %s", name)
+}
 
-    fmt.Printf("Approx Age: %d\n", approxAge)
-    return approxAge
+// ApproxAge guesses approx time when peers.dat was saved, 0 for an empty
+// file
+func ApproxAge(peersDb PeersDB, strategy ApproxAgeStrategy) uint32 {
+    // range over the parsed entries rather than the header counts, which a
+    // salvaged file may not match
+    var times []uint32
+    for i := 0; i < len(peersDb.NewAddrInfo); i++ {
+        times = append(times, peersDb.NewAddrInfo[i].Address.Time)
+    }
+    for i := 0; i < len(peersDb.TriedAddrInfo); i++ {
+        times = append(times, peersDb.TriedAddrInfo[i].Address.Time)
+    }
+    if len(times) == 0 {
+        return 0
+    }
+    sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+    // nearest rank percentiles
+    rank := func(percentile int) uint32 {
+        index := (percentile*len(times)+99)/100 - 1
+        if index < 0 {
+            index = 0
+        }
+        return times[index]
+    }
+    switch strategy {
+    case ApproxAgeMedian:
+        return rank(50)
+    case ApproxAgePercentile95:
+        return rank(95)
+    }
+    return times[len(times)-1]
 }
 
 // WriteArrayToFile takes array and writes to file
@@ -205,89 +605,650 @@ const FIVE_DAYS = 5 * ONE_DAY
 const TEN_DAYS = 2 * FIVE_DAYS
 const THIRTY_DAYS = 3 * TEN_DAYS
 
-// AddToAgeBucket computes age in days and increments respective age bucket
+// AddToAgeBucket computes age and increments respective age bucket. A zero
+// AgeBuckets is given the AgeBoundaries first.
 func AddToAgeBucket(ageBucket *AgeBuckets, ipTimestamp, approxAge uint32) {
+    if ageBucket.Counts == nil {
+        *ageBucket = NewAgeBuckets(AgeBoundaries...)
+    }
 
     ipAge := int(approxAge - ipTimestamp)
+    ageBucket.Counts[AgeBucketIndex(ageBucket.Boundaries, ipAge)]++
+}
 
-    if ipAge <= ONE_DAY {
-        ageBucket.LessThanOne++
-    } else if ipAge < FIVE_DAYS {
-        ageBucket.OneToFive++
-    } else if ipAge < TEN_DAYS {
-        ageBucket.FiveToTen++
-    } else if ipAge < THIRTY_DAYS {
-        ageBucket.TenToThirty++
-    } else {
-        ageBucket.GreaterThanThirty++
+// PrintDatadirReport compares peers.dat against the anchors and banlist
+// found in the datadir basePath, either of which may be missing
+func PrintDatadirReport(basePath string, peersDb PeersDB, newResult, triedResult *Result) error {
+    var anchors []CAddress
+    anchorsPath := filepath.Join(basePath, "anchors.dat")
+    if _, err := os.Stat(anchorsPath); err == nil {
+        anchorsDB, err := NewAnchorsDB(anchorsPath)
+        if err != nil {
+            return err
+        }
+        anchors = anchorsDB.Anchors
+    }
+
+    var banned []*net.IPNet
+    banlistPath := filepath.Join(basePath, "banlist.json")
+    if _, err := os.Stat(banlistPath); err == nil {
+        banned, err = ReadBanlist(banlistPath)
+        if err != nil {
+            return err
+        }
+    }
+
+    reachable := make(map[string]bool)
+    for _, ip := range append(append([]string{}, newResult.ReachableIPs...), triedResult.ReachableIPs...) {
+        reachable[ip] = true
+    }
+
+    report := CompareDatadir(peersDb, anchors, banned, reachable)
+
+    fmt.Printf("Anchors (%d):\n", len(report.Anchors))
+    for _, anchor := range report.Anchors {
+        fmt.Printf("  %s tried=%t new=%t reachable=%t\n", anchor.Address.PeerAddress.Key(), anchor.InTried, anchor.InNew, anchor.Reachable)
+    }
+    fmt.Printf("Banned addresses: %d new, %d tried\n", len(report.BannedNew), len(report.BannedTried))
+    for _, info := range append(append([]CAddrInfo{}, report.BannedNew...), report.BannedTried...) {
+        fmt.Printf("  %s\n", info.Address.PeerAddress.Key())
+    }
+    return nil
+}
+
+// sourceAnomalyThreshold is the number of clearnet addresses an onion source
+// must advertise before it is reported
+const sourceAnomalyThreshold = 10
+
+// PrintSourceNetworkReport prints the source/address network cross-tab of a
+// table followed by any anomalous sources
+func PrintSourceNetworkReport(table string, infos []CAddrInfo) {
+    counts := SourceNetworkCrossTab(infos)
+    pairs := make([]NetworkPair, 0, len(counts))
+    for pair := range counts {
+        pairs = append(pairs, pair)
+    }
+    sort.Slice(pairs, func(i, j int) bool {
+        if pairs[i].Source != pairs[j].Source {
+            return pairs[i].Source < pairs[j].Source
+        }
+        return pairs[i].Address < pairs[j].Address
+    })
+
+    fmt.Printf("Source network -> address network (%s table):\n", table)
+    for _, pair := range pairs {
+        fmt.Printf("  %s -> %s: %d\n", pair.Source, pair.Address, counts[pair])
     }
 
+    for _, anomaly := range SourceNetworkAnomalies(infos, sourceAnomalyThreshold) {
+        fmt.Printf("  anomaly: %s source %s advertised %d clearnet of %d addresses\n", anomaly.SourceNetwork, anomaly.Source, anomaly.Mismatched, anomaly.Total)
+    }
 }
 
-// WriteOutput dumps everything into files
-func WriteOutput(approxAge uint32, newResult, triedResult *Result, basePath string) {
-    newFile, _ := os.Create(basePath + "new-table-stats.txt")
-    triedFile, _ := os.Create(basePath + "tried-table-stats.txt")
+// PrintNetworkComparison prints the network composition of the new and
+// tried tables side by side. Networks the file's version can't record are
+// shown as n/a.
+func PrintNetworkComparison(peersDb PeersDB, newTableIPs, triedTableIPs []CAddrInfo) {
+    fmt.Println("Networks, new vs tried:")
+    fmt.Printf("  %-10s %10s %8s %10s %8s\n", "network", "new", "%", "tried", "%")
+    for _, share := range CompareNetworks(newTableIPs, triedTableIPs) {
+        if !peersDb.SupportsNetwork(share.Network) {
+            fmt.Printf("  %-10s %10s %8s %10s %8s\n", share.Network, "n/a", "", "n/a", "")
+            continue
+        }
+        fmt.Printf("  %-10s %10d %8s %10d %8s\n", share.Network, share.New, formatPercent(share.NewPercentage), share.Tried, formatPercent(share.TriedPercentage))
+    }
+}
 
-    defer newFile.Close()
-    defer triedFile.Close()
+// geoIPDB is loaded from -geoip on first use and shared by every node
+var geoIPDB *GeoIPDB
 
-    header := "Approx_Peerdat_Date,Oldest_IP_Days,Total_IPs,PercentReachable,Age_1,Age_1_5,Age_5_10,Age_10_30,Age_30"
+// PrintTopCountries prints the n countries with the most tabled addresses,
+// and separately with the most reachable ones, of each table
+func PrintTopCountries(newTableIPs, triedTableIPs []CAddrInfo, newResult, triedResult *Result, n int) error {
+    if geoIPPath == "" {
+        logger.Printf("-top-countries needs a GeoIP database, given with -geoip\n")
+        return nil
+    }
+    if geoIPDB == nil {
+        db, err := LoadGeoIPCSV(geoIPPath)
+        if err != nil {
+            return err
+        }
+        geoIPDB = db
+    }
 
-    newFile.WriteString(header + "\n")
-    triedFile.WriteString(header + "\n")
+    for _, table := range []struct {
+        name   string
+        infos  []CAddrInfo
+        result *Result
+    }{{"new", newTableIPs, newResult}, {"tried", triedTableIPs, triedResult}} {
+        countries := CountCountries(geoIPDB, table.infos, table.result)
+        fmt.Printf("Top %d countries by tabled addresses (%s table):\n", n, table.name)
+        for i, country := range countries {
+            if i == n {
+                break
+            }
+            fmt.Printf("  %s: %d (%s%%)\n", country.Country, country.Tabled, formatPercent(float64(country.Tabled)/float64(len(table.infos))))
+        }
 
-    pretty := func(result *Result) string {
-        approxAgeT := time.Unix(int64(approxAge), 0)
-        approxAgeStr := approxAgeT.Format("Jan 2 2006")
+        sort.SliceStable(countries, func(i, j int) bool {
+            return countries[i].Reachable > countries[j].Reachable
+        })
+        fmt.Printf("Top %d countries by reachable addresses (%s table):\n", n, table.name)
+        for i, country := range countries {
+            if i == n || country.Reachable == 0 {
+                break
+            }
+            fmt.Printf("  %s: %d (%s%%)\n", country.Country, country.Reachable, formatPercent(float64(country.Reachable)/float64(table.result.NumberOfReachableIPs)))
+        }
+    }
+    return nil
+}
 
-        daysOldestIP := strconv.Itoa((int(approxAge) - int(result.OldestIPAge)) / ONE_DAY)
-        totalIPs := strconv.Itoa(result.TotalIPs)
-        percent := strconv.FormatFloat(result.Percentage*100, 'f', 2, 64)
+// PrintExtremes prints the n oldest and newest entries of a table
+func PrintExtremes(table string, infos []CAddrInfo, n int) {
+    fmt.Printf("Oldest %d entries (%s table):\n", n, table)
+    for _, info := range OldestN(infos, n) {
+        fmt.Print(info)
+    }
+    fmt.Printf("Newest %d entries (%s table):\n", n, table)
+    for _, info := range NewestN(infos, n) {
+        fmt.Print(info)
+    }
+}
 
-        age_1 := strconv.Itoa(result.Age.LessThanOne)
-        age_1_5 := strconv.Itoa(result.Age.OneToFive)
-        age_5_10 := strconv.Itoa(result.Age.FiveToTen)
-        age_10_30 := strconv.Itoa(result.Age.TenToThirty)
-        age_30 := strconv.Itoa(result.Age.GreaterThanThirty)
+// WriteSeedList writes the reachable entries of both tables to path,
+// compressed with -compress
+func WriteSeedList(path string, newTableIPs, triedTableIPs []CAddrInfo, newResult, triedResult *Result) error {
+    seeds := SeedList(append(ReachableEntries(triedTableIPs, triedResult), ReachableEntries(newTableIPs, newResult)...))
 
-        resultSlice := []string{approxAgeStr, daysOldestIP, totalIPs, percent, age_1, age_1_5, age_5_10, age_10_30, age_30}
-        return strings.Join(resultSlice, ",")
+    file, err := CreateOutputFile(path)
+    if err != nil {
+        return err
     }
+    if err := ExportAddnode(seeds, file, false); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
 
-    newFile.WriteString(pretty(newResult) + "\n")
-    triedFile.WriteString(pretty(triedResult) + "\n")
+// runAnchors prints the anchors saved in an anchors.dat file
+func runAnchors(args []string) {
+    if len(args) != 1 {
+        fmt.Fprintln(os.Stderr, "USAGE: ./peer_stats anchors ./node1/anchors.dat")
+        os.Exit(1)
+    }
+
+    anchorsDB, err := NewAnchorsDB(args[0])
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+
+    for _, anchor := range anchorsDB.Anchors {
+        fmt.Printf("%s\nNetwork: %s\n\n", anchor, anchor.PeerAddress.Network())
+    }
 }
 
-func main() {
-    // get base path from first argument
-    basePath := os.Args[1]
-    // get bitnode timestamp directory from second
-    bitnodeBasePath := os.Args[2]
-    // get timestamps.txt path from third
-    tsFilePath := os.Args[3]
+// runInspect prints every decoded field of a single table entry
+func runInspect(args []string) {
+    inspectFlags := flag.NewFlagSet("inspect", flag.ExitOnError)
+    table := inspectFlags.String("table", "new", "the table to read the entry from {new|tried}")
+    index := inspectFlags.Int("index", 0, "the position of the entry in the table")
+
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "USAGE: ./peer_stats inspect ./node1/ --table tried --index 42")
+        os.Exit(1)
+    }
+    inspectFlags.Parse(args[1:])
+
+    peersFilePath, _ := NodePaths(args[0])
+    peersDb, err := NewPeersDB(peersFilePath)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
 
-    peersFilePath := basePath + "peers.dat"
+    var entries []CAddrInfo
+    switch *table {
+    case "new":
+        entries = peersDb.NewAddrInfo
+    case "tried":
+        entries = peersDb.TriedAddrInfo
+    default:
+        fmt.Fprintf(os.Stderr, "Invalid table %s\n", *table)
+        os.Exit(1)
+    }
+    if *index < 0 || *index >= len(entries) {
+        fmt.Fprintf(os.Stderr, "Index %d out of range, the %s table has %d entries\n", *index, *table, len(entries))
+        os.Exit(1)
+    }
+
+    info := entries[*index]
+    address := info.Address
+    source := info.SourceService()
+    fmt.Printf("Table: %s\n", *table)
+    fmt.Printf("Index: %d\n", *index)
+    fmt.Printf("Address: %s\n", address.PeerAddress.Host())
+    fmt.Printf("Network: %s\n", address.PeerAddress.Network())
+    fmt.Printf("Port: %d\n", address.PeerAddress.Port)
+    fmt.Printf("SerializationVersion: %s\n", hexstring(address.SerializationVersion))
+    fmt.Printf("Services: 0x%016x %s\n", address.Services(), strings.Join(ServiceNames(address.Services()), ","))
+    fmt.Printf("Time: %d (%s)\n", address.Time, isoTime(address.Time))
+    fmt.Printf("Source: %s (%s)\n", source.Host(), source.Network())
+    if info.LastSuccess == 0 {
+        fmt.Println("LastSuccess: never")
+    } else {
+        fmt.Printf("LastSuccess: %d (%s)\n", info.LastSuccess, isoTime(uint32(info.LastSuccess)))
+    }
+    fmt.Printf("Attempts: %d\n", info.Attempts)
+}
 
-    rawPeersDB, err := NewPeersDB(peersFilePath)
+// runJaccard prints the Jaccard similarity of two peers.dat files
+func runJaccard(args []string) {
+    if len(args) != 2 {
+        fmt.Fprintln(os.Stderr, "USAGE: ./peer_stats jaccard ./node1/peers.dat ./node2/peers.dat")
+        os.Exit(1)
+    }
 
+    a, err := NewPeersDB(args[0])
     if err != nil {
         fmt.Println(err)
+        os.Exit(1)
+    }
+    b, err := NewPeersDB(args[1])
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    fmt.Printf("%.4f\n", SimilarityJaccard(a, b))
+}
+
+// NodePaths resolves a node argument to the peers file to read and the
+// directory to write output into. A directory reads its peers.dat, anything
+// else is taken as the peers file itself, e.g. a renamed backup, with output
+// written alongside it.
+func NodePaths(node string) (peersFilePath, basePath string) {
+    if info, err := os.Stat(node); err == nil && !info.IsDir() {
+        return node, filepath.Dir(node)
+    }
+    return filepath.Join(node, "peers.dat"), node
+}
+
+// processNode computes the stats for peersFilePath and writes them to outPath,
+// returning the results of the new and tried tables. basePath is the node's
+// directory, which names it in the report and holds its other datadir files.
+func processNode(label, peersFilePath, basePath, outPath, bitnodeBasePath, tsFilePath string) (*Result, *Result, error) {
+
+    var rawPeersDB PeersDB
+    var err error
+    if lenient {
+        if xorKey != "" {
+            return nil, nil, fmt.Errorf("-lenient can't be combined with -xor-key")
+        }
+        var skipped []uint64
+        rawPeersDB, skipped, err = NewPeersDBLenient(peersFilePath)
+        if len(skipped) > 0 {
+            logger.Printf("Skipped %d unparseable records in %s at offsets %v\n", len(skipped), peersFilePath, skipped)
+        }
+        if err == nil && !rawPeersDB.VerifyChecksum() {
+            logger.Printf("Warning: %s fails its checksum, it may be corrupt\n", peersFilePath)
+        }
+    } else if xorKey != "" {
+        key, decodeErr := hex.DecodeString(xorKey)
+        if decodeErr != nil {
+            return nil, nil, fmt.Errorf("Invalid xor key %s", xorKey)
+        }
+        rawPeersDB, err = NewPeersDBWithXorKey(peersFilePath, key)
+    } else {
+        rawPeersDB, err = NewPeersDB(peersFilePath)
+    }
+
+    if err != nil {
+        return nil, nil, err
     }
 
     peersDb := PeersDB(rawPeersDB)
 
+    if validate {
+        if err := peersDb.Validate(); err != nil {
+            logger.Printf("Validate: %s is inconsistent:\n%s\n", peersFilePath, err)
+        }
+        implausible := HasImplausibleServices(DefaultServiceCeiling)
+        newBad := len(Filter(peersDb.NewAddrInfo, implausible))
+        triedBad := len(Filter(peersDb.TriedAddrInfo, implausible))
+        logger.Printf("Validate: %d new and %d tried entries advertise service bits from %d up\n", newBad, triedBad, DefaultServiceCeiling)
+        if newBad+triedBad > 0 {
+            logger.Printf("Warning: implausible service bits suggest the file is misparsed\n")
+        }
+    }
+
+    // a dominant port other than the default one gives away a peers.dat
+    // from another network mislabelled as this one
+    if chain, ok := ChainForMagic(peersDb.MessageBytes); ok {
+        for _, table := range []struct {
+            name  string
+            infos []CAddrInfo
+        }{{"new", peersDb.NewAddrInfo}, {"tried", peersDb.TriedAddrInfo}} {
+            if port, mismatch := DefaultPortMismatch(table.infos, chain); mismatch {
+                logger.Printf("Warning: most %s table entries use port %d but the %s default is %d, is this the right network?\n", table.name, port, chain.Name, chain.DefaultPort)
+            }
+        }
+    }
+
+    // entries from the future distort the approx age and every age after it
+    now := uint32(SystemClock.Now().Unix())
+    skew := CheckClockSkew(append(append([]CAddrInfo{}, peersDb.NewAddrInfo...), peersDb.TriedAddrInfo...), now, DefaultSkewThreshold)
+    if skew.Count() > 0 {
+        logger.Printf("Warning: %d entries are timestamped in the future, by up to %d seconds\n", skew.Count(), skew.MaxSkew)
+    }
+
     // get approx time when the file was saved
-    approxAge := ApproxAge(peersDb)
+    approxAge := ApproxAge(peersDb, approxAgeStrategy)
+    logger.Printf("Approx Age: %d\n", approxAge)
 
     // get closest bitnode timestamp
-    bitnodeTS := ClosestBitnodeTS(tsFilePath, approxAge)
-    fmt.Printf("Closest bitnode timestamp: %d\n", bitnodeTS)
+    bitnodeTS, err := ClosestBitnodeTS(tsFilePath, approxAge)
+    if err != nil {
+        return nil, nil, err
+    }
+    logger.Printf("Closest bitnode timestamp: %d\n", bitnodeTS)
 
     // get the set of reachable IPs
-    bitnodeBasePath += strconv.Itoa(int(bitnodeTS)) + ".txt"
-    newResult, oldResult := ComputeStats(bitnodeBasePath, approxAge, peersDb.NewAddrInfo, peersDb.TriedAddrInfo)
+    bitnodeBasePath = filepath.Join(bitnodeBasePath, strconv.Itoa(int(bitnodeTS))+".txt")
+    newTableIPs := peersDb.NewAddrInfo
+    triedTableIPs := peersDb.TriedAddrInfo
+
+    var preds []Predicate
+    if dropInvalidPorts {
+        preds = append(preds, HasValidPort)
+    }
+    if dropInternal {
+        preds = append(preds, Not(ByNetwork(NetworkInternal)))
+    }
+    if maxAgeDays > 0 {
+        maxAge := MaxAge(approxAge, uint32(maxAgeDays*ONE_DAY))
+        dropped := len(newTableIPs) + len(triedTableIPs) - len(Filter(newTableIPs, maxAge)) - len(Filter(triedTableIPs, maxAge))
+        logger.Printf("Dropping %d entries older than %d days\n", dropped, maxAgeDays)
+        preds = append(preds, maxAge)
+    }
+    // an entry must match -include-regex and must not match -exclude-regex,
+    // so exclusion wins when both match
+    if includeRegex != "" {
+        re, reErr := regexp.Compile(includeRegex)
+        if reErr != nil {
+            return nil, nil, fmt.Errorf("Invalid -include-regex: %v", reErr)
+        }
+        preds = append(preds, HostMatches(re))
+    }
+    if excludeRegex != "" {
+        re, reErr := regexp.Compile(excludeRegex)
+        if reErr != nil {
+            return nil, nil, fmt.Errorf("Invalid -exclude-regex: %v", reErr)
+        }
+        preds = append(preds, Not(HostMatches(re)))
+    }
+    if len(preds) > 0 {
+        newTableIPs = Filter(newTableIPs, preds...)
+        triedTableIPs = Filter(triedTableIPs, preds...)
+        logger.Printf("Filtered out %d new and %d tried entries\n", len(peersDb.NewAddrInfo)-len(newTableIPs), len(peersDb.TriedAddrInfo)-len(triedTableIPs))
+    }
+
+    var newResult, oldResult *Result
+    if networkScope != "" {
+        network, parseErr := ParseNetwork(networkScope)
+        if parseErr != nil {
+            return nil, nil, parseErr
+        }
+        if err := peersDb.RequireNetwork(network); err != nil {
+            return nil, nil, err
+        }
+        newResult, oldResult, err = ComputeStatsForNetwork(bitnodeBasePath, approxAge, network, newTableIPs, triedTableIPs)
+    } else {
+        newResult, oldResult, err = ComputeStats(bitnodeBasePath, approxAge, newTableIPs, triedTableIPs)
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if newResult.NoCrawlData {
+        logger.Printf("Warning: bitnode snapshot %s is empty, reachability is unknown\n", bitnodeBasePath)
+    }
+    for _, warning := range append(newResult.Warnings, oldResult.Warnings...) {
+        logger.Printf("Warning: %s\n", warning)
+    }
+    newResult.Label = label
+    oldResult.Label = label
 
     // write output
-    WriteOutput(approxAge, newResult, oldResult, basePath)
+    if err := os.MkdirAll(outPath, 0755); err != nil {
+        return nil, nil, err
+    }
+    writer, err := NewOutputWriter(outputFormat, outPath)
+    if err != nil {
+        return nil, nil, err
+    }
+    if err := WriteOutput(writer, newResult, oldResult); err != nil {
+        return nil, nil, err
+    }
+
+    if onlyReachable {
+        if err := WriteReachableEntries(outPath, "new", entriesFormat(outputFormat), newTableIPs, newResult); err != nil {
+            return nil, nil, err
+        }
+        if err := WriteReachableEntries(outPath, "tried", entriesFormat(outputFormat), triedTableIPs, oldResult); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if seedOut != "" {
+        // in batch mode each node writes its own list into its output
+        // directory rather than all of them over the one path
+        seedPath := seedOut
+        if batchMode {
+            seedPath = filepath.Join(outPath, filepath.Base(seedOut))
+        }
+        if err := WriteSeedList(seedPath, newTableIPs, triedTableIPs, newResult, oldResult); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if RetainBuckets {
+        err := writeTableFile(filepath.Join(outPath, "new-table-buckets.txt"), func(file io.Writer) error {
+            return WriteBucketEntries(file, peersDb)
+        })
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if networksSummary {
+        summary := SummarizeNetworks(newTableIPs, triedTableIPs)
+        summary.restrict(peersDb)
+        if err := WriteNetworksSummary(summary, outPath); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if !quiet {
+        fmt.Println(Report(filepath.Base(filepath.Clean(basePath)), newResult, oldResult))
+
+        overlap := peersDb.TriedNewOverlap()
+        fmt.Printf("  overlap: %d addresses in both tables (%.1f%% of tried, %.1f%% of new)\n", overlap.Count, overlap.TriedFraction*100, overlap.NewFraction*100)
+    }
+
+    if diffDatadir {
+        if err := PrintDatadirReport(basePath, peersDb, newResult, oldResult); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if extremesCount > 0 {
+        PrintExtremes("new", newTableIPs, extremesCount)
+        PrintExtremes("tried", triedTableIPs, extremesCount)
+    }
+
+    if sourceNetworkMismatch {
+        PrintSourceNetworkReport("new", newTableIPs)
+        PrintSourceNetworkReport("tried", triedTableIPs)
+    }
+
+    if compareNetworks {
+        PrintNetworkComparison(peersDb, newTableIPs, triedTableIPs)
+    }
+
+    if topCountries > 0 {
+        if err := PrintTopCountries(newTableIPs, triedTableIPs, newResult, oldResult, topCountries); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    return newResult, oldResult, nil
+}
+
+// NodeLabel splits a batch mode node argument given as label=path. Without
+// a label the node is labelled by its directory name.
+func NodeLabel(node string) (label, path string) {
+    if label, path, found := strings.Cut(node, "="); found && label != "" {
+        return label, path
+    }
+    _, basePath := NodePaths(node)
+    return filepath.Base(filepath.Clean(basePath)), node
+}
+
+// runBatch processes several node directories against the same bitnode
+// archive, recording progress in the manifest so that a rerun with -resume
+// continues where it left off
+func runBatch(args []string) {
+    if len(args) < 3 {
+        fmt.Fprintln(os.Stderr, "USAGE: ./peer_stats [-resume] batch /data/bitnodes/stripped/ /data/bitnodes/timestamps.txt ./node1/ node2=./node2/ ...")
+        os.Exit(1)
+    }
+    bitnodeBasePath := args[0]
+    tsFilePath := args[1]
+    batchMode = true
+
+    manifest, err := LoadManifest(manifestPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Couldn't load manifest %s: %s\n", manifestPath, err)
+        os.Exit(1)
+    }
+
+    var newResults, triedResults []*Result
+    failed := false
+    for _, node := range args[2:] {
+        label, node := NodeLabel(node)
+        peersFilePath, basePath := NodePaths(node)
+        if resume && manifest.Done(peersFilePath) {
+            logger.Printf("Skipping %s, already processed\n", peersFilePath)
+            continue
+        }
+
+        outPath := basePath
+        if outDir != "" {
+            outPath = filepath.Join(outDir, label)
+        }
+        newResult, triedResult, err := processNode(label, peersFilePath, basePath, outPath, bitnodeBasePath, tsFilePath)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %s\n", node, err)
+            failed = true
+            continue
+        }
+        newResults = append(newResults, newResult)
+        triedResults = append(triedResults, triedResult)
+
+        if err := manifest.MarkDone(peersFilePath); err != nil {
+            fmt.Fprintf(os.Stderr, "Couldn't update manifest %s: %s\n", manifestPath, err)
+            os.Exit(1)
+        }
+    }
+
+    if dailyOut != "" {
+        // nodes skipped by -resume aren't part of the rollup
+        for table, results := range map[string][]*Result{"new": newResults, "tried": triedResults} {
+            err := writeTableFile(filepath.Join(dailyOut, table+"-daily-stats.txt"), func(file io.Writer) error {
+                return WriteRollups(file, RollupByDay(results))
+            })
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Couldn't write daily rollup: %s\n", err)
+                os.Exit(1)
+            }
+        }
+    }
+
+    if failed {
+        os.Exit(1)
+    }
+}
+
+// fillPaths completes the paths given by the named flags, in order the node
+// directory or peers file, the bitnode snapshot directory and
+// timestamps.txt, with the positional arguments: each path not given by
+// its flag takes the next argument. It fails if a path is left missing or
+// an argument left over.
+func fillPaths(named []string, args []string) ([]string, error) {
+    paths := append([]string{}, named...)
+    for i := range paths {
+        if paths[i] == "" && len(args) > 0 {
+            paths[i], args = args[0], args[1:]
+        }
+        if paths[i] == "" {
+            return nil, fmt.Errorf("Missing the %s", pathNames[i])
+        }
+    }
+    if len(args) > 0 {
+        return nil, fmt.Errorf("Unexpected arguments %s", strings.Join(args, " "))
+    }
+    return paths, nil
+}
+
+var pathNames = []string{"node directory or peers file", "bitnode snapshot directory", "timestamps file"}
+
+func main() {
+    parseFlags()
+    if flag.Arg(0) == "jaccard" {
+        runJaccard(flag.Args()[1:])
+        return
+    }
+    if flag.Arg(0) == "inspect" {
+        runInspect(flag.Args()[1:])
+        return
+    }
+    if flag.Arg(0) == "anchors" {
+        runAnchors(flag.Args()[1:])
+        return
+    }
+    if flag.Arg(0) == "batch" {
+        runBatch(flag.Args()[1:])
+        return
+    }
+
+    paths, err := fillPaths([]string{peersArg, bitnodesDir, timestampsPath}, flag.Args())
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        flag.Usage()
+        os.Exit(1)
+    }
+    node, bitnodeBasePath, tsFilePath := paths[0], paths[1], paths[2]
+
+    peersFilePath, basePath := NodePaths(node)
+    for _, path := range []string{peersFilePath, bitnodeBasePath, tsFilePath} {
+        if _, err := os.Stat(path); err != nil {
+            fmt.Fprintf(os.Stderr, "Couldn't find %s\n", path)
+            flag.Usage()
+            os.Exit(1)
+        }
+    }
+
+    outPath := basePath
+    if outDir != "" {
+        outPath = outDir
+    }
+    if _, _, err := processNode(nodeLabel, peersFilePath, basePath, outPath, bitnodeBasePath, tsFilePath); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComputeStatsEmptyTables(t *testing.T) {
+	newResult, triedResult, err := ComputeStats(writeBitnodes(t, "1.2.3.4"), 1700000000, nil, []CAddrInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range []*Result{newResult, triedResult} {
+		if math.IsNaN(result.Percentage) || result.Percentage != 0 || result.TotalIPs != 0 {
+			t.Errorf("got %v of %d entries reachable, want 0", result.Percentage, result.TotalIPs)
+		}
+		for _, field := range csvRow(result) {
+			if strings.Contains(field, "NaN") {
+				t.Errorf("empty table row %q holds NaN", csvRow(result))
+				break
+			}
+		}
+	}
+}
+
+func TestDuplicateBitnodeLinesCapAt100Percent(t *testing.T) {
+	table := []CAddrInfo{addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork), addrInfo("5.6.7.8", 8333, 1700000000, NodeNetwork)}
+	bitnodes := writeBitnodes(t, "1.2.3.4", "1.2.3.4", "5.6.7.8", "::ffff:1.2.3.4", "5.6.7.8", "9.9.9.9")
+	newResult, _, err := ComputeStats(bitnodes, 1700000000, table, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newResult.NumberOfReachableIPs != 2 || newResult.Percentage != 1 || len(newResult.ReachableIPs) != 2 {
+		t.Errorf("got %d reachable (%v), want each tabled address counted once", newResult.NumberOfReachableIPs, newResult.Percentage)
+	}
+	if len(newResult.Warnings) != 0 {
+		t.Errorf("got warnings %q", newResult.Warnings)
+	}
+}
+
+func TestCheckPercentageCaps(t *testing.T) {
+	result := CreateResult()
+	result.TotalIPs, result.NumberOfReachableIPs, result.Percentage = 2, 3, 1.5
+	result.checkPercentage("new")
+	if result.NumberOfReachableIPs != 2 || result.Percentage != 1 || len(result.Warnings) != 1 {
+		t.Errorf("got %d reachable (%v) and warnings %q, want the count capped with a warning", result.NumberOfReachableIPs, result.Percentage, result.Warnings)
+	}
+}
+
+func TestBitnodeLongLines(t *testing.T) {
+	table := []CAddrInfo{addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork)}
+
+	// lines past bufio.Scanner's default 64KB limit, and binary junk, are
+	// read rather than ending the scan early
+	junk := string([]byte{0xff, 0xfe, 0x00, 0x80, 0xc3})
+	bitnodes := writeBitnodes(t, strings.Repeat("x", 100*1024), junk, "1.2.3.4")
+	newResult, _, err := ComputeStats(bitnodes, 1700000000, table, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newResult.NumberOfReachableIPs != 1 || newResult.SnapshotNetworkSize != 3 {
+		t.Errorf("got %d reachable of a %d line snapshot, want 1 of 3", newResult.NumberOfReachableIPs, newResult.SnapshotNetworkSize)
+	}
+
+	// a line past MaxBitnodeLineSize is an error, not a silent undercount
+	defer func(size int) { MaxBitnodeLineSize = size }(MaxBitnodeLineSize)
+	MaxBitnodeLineSize = 64 * 1024
+	if _, _, err := ComputeStats(bitnodes, 1700000000, table, nil); err == nil {
+		t.Error("got no error for a line past MaxBitnodeLineSize")
+	}
+}
+
+func TestStrictReachability(t *testing.T) {
+	table := []CAddrInfo{
+		addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork),
+		addrInfo("1.2.3.4", 8334, 1700000000, NodeNetwork),
+		addrInfo("2001:db8::1", 8333, 1700000000, NodeNetwork),
+		addrInfo("5.6.7.8", 8333, 1700000000, NodeNetwork),
+	}
+	bitnodes := writeBitnodes(t, "1.2.3.4:8333", "[2001:db8::1]:18333", "5.6.7.8:8333")
+
+	// by host alone the ports are ignored, collapsing the two entries of
+	// 1.2.3.4 and matching the IPv6 entry on another port
+	defer func() { StrictReachability = false }()
+	for strict, want := range map[bool]int{false: 3, true: 2} {
+		StrictReachability = strict
+		newResult, _, err := ComputeStats(bitnodes, 1700000000, table, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newResult.NumberOfReachableIPs != want {
+			t.Errorf("strict %t: got %d reachable %q, want %d", strict, newResult.NumberOfReachableIPs, newResult.ReachableIPs, want)
+		}
+	}
+}
+
+func TestBinSearch(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		elem    uint32
+		tsArray []uint32
+		want    uint32
+		index   int
+	}{
+		{"empty", 5, nil, 0, -1},
+		{"single below", 5, []uint32{10}, 10, 0},
+		{"single above", 15, []uint32{10}, 10, 0},
+		{"single exact", 10, []uint32{10}, 10, 0},
+		{"exact first", 10, []uint32{10, 20, 30}, 10, 0},
+		{"exact middle", 20, []uint32{10, 20, 30}, 20, 1},
+		{"exact last", 30, []uint32{10, 20, 30}, 30, 2},
+		{"below minimum", 1, []uint32{10, 20, 30}, 10, 0},
+		{"above maximum", 99, []uint32{10, 20, 30}, 30, 2},
+		{"closer to lower", 12, []uint32{10, 20, 30}, 10, 0},
+		{"closer to upper", 28, []uint32{10, 20, 30}, 30, 2},
+		{"tie goes to later", 15, []uint32{10, 20, 30}, 20, 1},
+		{"tie in even array", 35, []uint32{10, 20, 30, 40}, 40, 3},
+		{"large array", 1699990100, []uint32{1600000000, 1650000000, 1699990000, 1700000000, 1800000000}, 1699990000, 2},
+	} {
+		got, index := BinSearch(test.elem, test.tsArray)
+		if got != test.want || index != test.index {
+			t.Errorf("%s: BinSearch(%d) = %d, %d, want %d, %d", test.name, test.elem, got, index, test.want, test.index)
+		}
+	}
+}
+
+func TestClosestBitnodeTS(t *testing.T) {
+	path := writeFixture(t, "timestamps.txt", []byte("1600000000\n\n1699990000\n"))
+	if ts, err := ClosestBitnodeTS(path, 1700000000); err != nil || ts != 1699990000 {
+		t.Errorf("got %d, %v, want 1699990000", ts, err)
+	}
+
+	for name, contents := range map[string]string{"garbage": "1600000000\nnot a timestamp\n", "empty": "", "negative": "-5\n"} {
+		if ts, err := ClosestBitnodeTS(writeFixture(t, "timestamps.txt", []byte(contents)), 1700000000); err == nil {
+			t.Errorf("%s: got %d, want an error", name, ts)
+		}
+	}
+	if _, err := ClosestBitnodeTS(path+".missing", 1700000000); err == nil {
+		t.Error("missing file: want an error")
+	}
+}
+
+func TestFillPaths(t *testing.T) {
+	for _, test := range []struct {
+		named []string
+		args  []string
+		want  []string
+	}{
+		{[]string{"", "", ""}, []string{"node", "bn", "ts"}, []string{"node", "bn", "ts"}},
+		{[]string{"node", "bn", "ts"}, nil, []string{"node", "bn", "ts"}},
+		{[]string{"node", "", ""}, []string{"bn", "ts"}, []string{"node", "bn", "ts"}},
+		{[]string{"", "", "ts"}, []string{"node", "bn"}, []string{"node", "bn", "ts"}},
+		{[]string{"", "bn", ""}, []string{"node", "ts"}, []string{"node", "bn", "ts"}},
+		{[]string{"node", "", ""}, []string{"bn"}, nil},
+		{[]string{"node", "bn", "ts"}, []string{"extra"}, nil},
+		{[]string{"", "", ""}, []string{"node", "bn", "ts", "extra"}, nil},
+	} {
+		got, err := fillPaths(test.named, test.args)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("fillPaths(%q, %q) = %q, want an error", test.named, test.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("fillPaths(%q, %q) = %q, %v, want %q", test.named, test.args, got, err, test.want)
+		}
+	}
+}
+
+func TestWriteSeedListCompressed(t *testing.T) {
+	compressOutput = true
+	defer func() { compressOutput = false }()
+
+	table := []CAddrInfo{addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork), addrInfo("5.6.7.8", 8333, 1700000000, NodeNetwork)}
+	newResult, triedResult, err := ComputeStats(writeBitnodes(t, "1.2.3.4"), 1700000000, table, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	if err := WriteSeedList(path, table, nil, newResult, triedResult); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(seeds), "1.2.3.4:8333") || strings.Contains(string(seeds), "5.6.7.8") {
+		t.Errorf("got seed list %q, want only 1.2.3.4:8333", seeds)
+	}
+}
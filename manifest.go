@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Manifest records which input files a batch run has processed, keyed by
+// path, along with their modification time when processed
+type Manifest struct {
+	Path      string           `json:"-"`
+	Processed map[string]int64 `json:"processed"`
+}
+
+// LoadManifest reads the manifest at path. A missing file yields an empty
+// manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := &Manifest{
+		Path:      path,
+		Processed: make(map[string]int64),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Processed == nil {
+		manifest.Processed = make(map[string]int64)
+	}
+	return manifest, nil
+}
+
+// Done reports whether file was processed and hasn't changed since
+func (manifest *Manifest) Done(file string) bool {
+	mtime, ok := manifest.Processed[file]
+	if !ok {
+		return false
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().UnixNano() == mtime
+}
+
+// MarkDone records file as processed at its current modification time and
+// saves the manifest
+func (manifest *Manifest) MarkDone(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	manifest.Processed[file] = info.ModTime().UnixNano()
+	return manifest.Save()
+}
+
+// Save writes the manifest, replacing the previous one atomically so a
+// crash mid-write can't lose the progress already recorded
+func (manifest *Manifest) Save() error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(manifest.Path), ".manifest-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), manifest.Path)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Merge combines the tables of several databases into one, deduplicated by
+// host and port. A duplicated address keeps its most recently heard of
+// entry, and is put in the tried table if any of the databases had tried
+// it. Entries stay in the order they're first seen, the new table's before
+// the tried table's, and NNew and NTried are set to the merged counts.
+//
+// The databases must be of the same network, or an error is returned. The
+// header is otherwise that of the first database, with the format and the
+// lowest compatible format raised to the highest of the inputs', so that
+// addrv2 entries merged into a legacy file are still readable. The bucket
+// layout is dropped: serializing the
+// result makes Core rebucket its new table on load. A merge holding more
+// entries than addrman can, which neither Core nor the parser would load,
+// gives an error wrapping ErrImplausibleCount along with the merged tables.
+func Merge(dbs ...PeersDB) (PeersDB, error) {
+	var merged PeersDB
+	if len(dbs) == 0 {
+		return merged, nil
+	}
+	first := dbs[0]
+	merged.MessageBytes = first.MessageBytes
+	merged.Version = first.Version
+	merged.KeySize = first.KeySize
+	merged.NKey = first.NKey
+	merged.NewBuckets = first.NewBuckets
+	for _, db := range dbs[1:] {
+		if !bytes.Equal(db.MessageBytes, first.MessageBytes) {
+			return merged, fmt.Errorf("Couldn't merge %s database with %s one", db.NetworkName(), first.NetworkName())
+		}
+		if db.Version > merged.Version {
+			merged.Version = db.Version
+		}
+		if db.KeySize > merged.KeySize {
+			merged.KeySize = db.KeySize
+		}
+	}
+
+	type mergedEntry struct {
+		info  CAddrInfo
+		tried bool
+	}
+	var order []string
+	entries := make(map[string]*mergedEntry)
+	for _, db := range dbs {
+		db.Iterate(func(info CAddrInfo, table TableKind) bool {
+			key := info.Address.PeerAddress.Key()
+			entry, ok := entries[key]
+			if !ok {
+				entry = &mergedEntry{info: info}
+				entries[key] = entry
+				order = append(order, key)
+			} else if info.Address.Time > entry.info.Address.Time {
+				entry.info = info
+			}
+			entry.tried = entry.tried || table == TableTried
+			return true
+		})
+	}
+
+	for _, key := range order {
+		entry := entries[key]
+		if entry.tried {
+			merged.TriedAddrInfo = append(merged.TriedAddrInfo, entry.info)
+		} else {
+			merged.NewAddrInfo = append(merged.NewAddrInfo, entry.info)
+		}
+	}
+	merged.NNew = uint32(len(merged.NewAddrInfo))
+	merged.NTried = uint32(len(merged.TriedAddrInfo))
+	if err := checkCounts(merged); err != nil {
+		return merged, fmt.Errorf("Couldn't merge %d databases: %w", len(dbs), err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	a := PeersDB{
+		NewAddrInfo:   []CAddrInfo{addrInfo("1.1.1.1", 8333, 100, NodeNetwork), addrInfo("2.2.2.2", 8333, 100, NodeNetwork)},
+		TriedAddrInfo: []CAddrInfo{addrInfo("3.3.3.3", 8333, 100, NodeNetwork)},
+	}
+	b := PeersDB{
+		NewAddrInfo:   []CAddrInfo{addrInfo("1.1.1.1", 8333, 200, NodeWitness), addrInfo("3.3.3.3", 8333, 300, NodeNetwork), addrInfo("1.1.1.1", 8334, 100, NodeNetwork)},
+		TriedAddrInfo: []CAddrInfo{addrInfo("2.2.2.2", 8333, 50, NodeNetwork)},
+	}
+
+	merged, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.NNew != 2 || merged.NTried != 2 {
+		t.Fatalf("got %d new and %d tried entries, want 2 and 2", merged.NNew, merged.NTried)
+	}
+	if got := merged.NewAddrInfo[0]; got.Address.PeerAddress.Key() != "1.1.1.1:8333" || got.Address.Time != 200 || got.Address.Services() != NodeWitness {
+		t.Errorf("duplicate kept %s at %d, want the later entry", got.Address.PeerAddress.Key(), got.Address.Time)
+	}
+	if got := merged.NewAddrInfo[1].Address.PeerAddress.Key(); got != "1.1.1.1:8334" {
+		t.Errorf("got %s, want the other port kept apart", got)
+	}
+	// tried in either database puts an entry in the tried table
+	for i, want := range []string{"2.2.2.2:8333", "3.3.3.3:8333"} {
+		if got := merged.TriedAddrInfo[i].Address.PeerAddress.Key(); got != want {
+			t.Errorf("tried entry %d is %s, want %s", i, got, want)
+		}
+	}
+	if merged.TriedAddrInfo[1].Address.Time != 300 {
+		t.Errorf("got time %d, want the later entry's", merged.TriedAddrInfo[1].Address.Time)
+	}
+}
+
+func TestMergeOverCapacity(t *testing.T) {
+	var a, b PeersDB
+	for i := 0; i < maxTried; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).String()
+		a.TriedAddrInfo = append(a.TriedAddrInfo, addrInfo(ip, 8333, 100, NodeNetwork))
+	}
+	b.TriedAddrInfo = []CAddrInfo{addrInfo("11.0.0.1", 8333, 100, NodeNetwork)}
+
+	if _, err := Merge(a); err != nil {
+		t.Errorf("a full tried table failed to merge: %s", err)
+	}
+	if merged, err := Merge(a, b); !errors.Is(err, ErrImplausibleCount) {
+		t.Errorf("got %v merging %d tried entries, want ErrImplausibleCount", err, merged.NTried)
+	}
+}
+
+func TestMergeFormats(t *testing.T) {
+	legacy := parseFixture(t, legacyFixture(4, 1700000000))
+	addrV2 := parseFixture(t, mainnetFixture())
+
+	merged, err := Merge(legacy, addrV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Version != addrV2.Version || merged.KeySize != addrV2.KeySize {
+		t.Errorf("got format %d compatible with %d, want the addrv2 file's %d and %d", merged.Version, merged.LowestCompatible(), addrV2.Version, addrV2.LowestCompatible())
+	}
+	var out bytes.Buffer
+	if err := merged.Serialize(&out); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := NewPeersDB(writeFixture(t, "peers.dat", out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reread.NNew != merged.NNew || reread.NTried != merged.NTried {
+		t.Errorf("reread %d new and %d tried entries, want %d and %d", reread.NNew, reread.NTried, merged.NNew, merged.NTried)
+	}
+
+	testnet := addrV2
+	testnet.MessageBytes = []byte{0x0b, 0x11, 0x09, 0x07}
+	if _, err := Merge(legacy, testnet); err == nil {
+		t.Error("merged a mainnet and a testnet database")
+	}
+}
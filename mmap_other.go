@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// mapFile falls back to reading the file into memory where mmap isn't
+// available
+func mapFile(path string) ([]byte, func() error, error) {
+	return readFile(path)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// NetworksSummary counts the addresses of each table by network. It is
+// written as JSON and versioned with JSONSchemaVersion.
+type NetworksSummary struct {
+	SchemaVersion   int            `json:"schema_version"`
+	New             map[string]int `json:"new"`
+	Tried           map[string]int `json:"tried"`
+	UniqueAddresses int            `json:"unique_addresses"`
+}
+
+// networkCounts counts infos by network, including every known network so
+// that the summary always has the same keys
+func networkCounts(infos []CAddrInfo) map[string]int {
+	counts := make(map[string]int)
+	for network := range networkNames {
+		if network != NetworkUnknown {
+			counts[network.String()] = 0
+		}
+	}
+	for _, info := range infos {
+		counts[info.Address.PeerAddress.Network().String()]++
+	}
+	return counts
+}
+
+// SummarizeNetworks builds the per-network summary of both tables
+func SummarizeNetworks(newTableIPs, triedTableIPs []CAddrInfo) NetworksSummary {
+	unique := make(map[string]bool, len(newTableIPs)+len(triedTableIPs))
+	for _, infos := range [][]CAddrInfo{newTableIPs, triedTableIPs} {
+		for _, info := range infos {
+			unique[info.Address.PeerAddress.Key()] = true
+		}
+	}
+
+	return NetworksSummary{
+		SchemaVersion:   JSONSchemaVersion,
+		New:             networkCounts(newTableIPs),
+		Tried:           networkCounts(triedTableIPs),
+		UniqueAddresses: len(unique),
+	}
+}
+
+// restrict drops the networks the file's version can't record, which would
+// otherwise read as zero counts
+func (summary NetworksSummary) restrict(peersDB PeersDB) {
+	for network := range networkCapabilities {
+		if !peersDB.SupportsNetwork(network) {
+			delete(summary.New, network.String())
+			delete(summary.Tried, network.String())
+		}
+	}
+}
+
+// WriteNetworksSummary writes the summary to networks-summary.json in basePath
+func WriteNetworksSummary(summary NetworksSummary, basePath string) error {
+	file, err := CreateOutputFile(filepath.Join(basePath, "networks-summary.json"))
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	if prettyJSON {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// NetworkShare is one row of the new against tried network comparison:
+// the number of addresses of a network in each table and their share of it
+type NetworkShare struct {
+	Network         Network
+	New             int
+	Tried           int
+	NewPercentage   float64
+	TriedPercentage float64
+}
+
+// CompareNetworks tabulates the network composition of both tables side by
+// side, one row per known network in enum order. A network making up a
+// larger share of new than of tried is being promoted less often.
+func CompareNetworks(newTableIPs, triedTableIPs []CAddrInfo) []NetworkShare {
+	newCounts := networkCounts(newTableIPs)
+	triedCounts := networkCounts(triedTableIPs)
+
+	shares := make([]NetworkShare, 0, len(networkNames))
+	for network := NetworkUnknown; int(network) < len(networkNames); network++ {
+		name := network.String()
+		share := NetworkShare{
+			Network: network,
+			New:     newCounts[name],
+			Tried:   triedCounts[name],
+		}
+		if network == NetworkUnknown && share.New == 0 && share.Tried == 0 {
+			continue
+		}
+		if len(newTableIPs) > 0 {
+			share.NewPercentage = float64(share.New) / float64(len(newTableIPs))
+		}
+		if len(triedTableIPs) > 0 {
+			share.TriedPercentage = float64(share.Tried) / float64(len(triedTableIPs))
+		}
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// ReportedNetworks are the networks the stats output breaks reachability
+// down by
+var ReportedNetworks = []Network{NetworkIPv4, NetworkIPv6, NetworkTor, NetworkI2P}
+
+// NetworkReachability counts the entries of a network and how many of them
+// were reachable. Crawled is false if the bitnode snapshot lists no host of
+// the network at all, in which case the reachable count says nothing.
+type NetworkReachability struct {
+	Network   Network
+	Total     int
+	Reachable int
+	Crawled   bool
+}
+
+// Percentage returns the reachable fraction, 0 when the table has no entry
+// of the network
+func (n NetworkReachability) Percentage() float64 {
+	if n.Total == 0 {
+		return 0
+	}
+	return float64(n.Reachable) / float64(n.Total)
+}
+
+// NetworkBreakdown computes reachability for each of ReportedNetworks among
+// the entries of table, given the reachable IPs found in it and the
+// networks the bitnode snapshot has hosts of
+func NetworkBreakdown(table []CAddrInfo, reachableIPs []string, crawled map[Network]bool) []NetworkReachability {
+	reachable := make(map[string]bool, len(reachableIPs))
+	for _, ip := range reachableIPs {
+		reachable[ip] = true
+	}
+
+	breakdown := make([]NetworkReachability, len(ReportedNetworks))
+	index := make(map[Network]int, len(ReportedNetworks))
+	for i, network := range ReportedNetworks {
+		breakdown[i].Network = network
+		breakdown[i].Crawled = crawled[network]
+		index[network] = i
+	}
+
+	for _, info := range table {
+		i, ok := index[info.Address.PeerAddress.Network()]
+		if !ok {
+			continue
+		}
+		breakdown[i].Total++
+		if reachable[reachabilityKey(info.Address.PeerAddress)] {
+			breakdown[i].Reachable++
+		}
+	}
+	return breakdown
+}
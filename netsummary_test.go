@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestCompareNetworks(t *testing.T) {
+	newTable := []CAddrInfo{
+		addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork),
+		addrInfo("5.6.7.8", 8333, 1700000000, NodeNetwork),
+		addrInfo("2001:4860::1", 8333, 1700000000, NodeNetwork),
+	}
+	onion := addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork)
+	onion.Address.PeerAddress = CService{IPAddress: bytes.Repeat([]byte{0x42}, 32), Port: 8333, NetworkID: BIP155TorV3}
+	newTable = append(newTable, onion)
+	triedTable := []CAddrInfo{addrInfo("9.9.9.9", 8333, 1700000000, NodeNetwork)}
+
+	shares := CompareNetworks(newTable, triedTable)
+	var newSum, triedSum float64
+	counts := make(map[Network][2]int)
+	for _, share := range shares {
+		newSum += share.NewPercentage
+		triedSum += share.TriedPercentage
+		counts[share.Network] = [2]int{share.New, share.Tried}
+	}
+	if math.Abs(newSum-1) > 1e-9 || math.Abs(triedSum-1) > 1e-9 {
+		t.Errorf("percentages sum to %v for new and %v for tried, want 1", newSum, triedSum)
+	}
+	for network, want := range map[Network][2]int{NetworkIPv4: {2, 1}, NetworkIPv6: {1, 0}, NetworkTor: {1, 0}, NetworkI2P: {0, 0}} {
+		if counts[network] != want {
+			t.Errorf("%s: got %v new and tried, want %v", network, counts[network], want)
+		}
+	}
+	if _, ok := counts[NetworkUnknown]; ok {
+		t.Error("got a row for unknown addresses, of which there are none")
+	}
+
+	// an empty table's shares are all 0 rather than NaN
+	for _, share := range CompareNetworks(newTable, nil) {
+		if share.TriedPercentage != 0 {
+			t.Errorf("%s: got %v of an empty tried table", share.Network, share.TriedPercentage)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha3"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Network identifies the network an address belongs to
+type Network uint8
+
+const (
+	NetworkUnknown Network = iota
+	NetworkIPv4
+	NetworkIPv6
+	NetworkTor
+	NetworkI2P
+	NetworkCJDNS
+	NetworkInternal
+)
+
+var networkNames = map[Network]string{
+	NetworkUnknown:  "unknown",
+	NetworkIPv4:     "ipv4",
+	NetworkIPv6:     "ipv6",
+	NetworkTor:      "onion",
+	NetworkI2P:      "i2p",
+	NetworkCJDNS:    "cjdns",
+	NetworkInternal: "internal",
+}
+
+func (network Network) String() string {
+	if name, ok := networkNames[network]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseNetwork returns the network with the given name
+func ParseNetwork(name string) (Network, error) {
+	for network, networkName := range networkNames {
+		if network != NetworkUnknown && strings.EqualFold(name, networkName) {
+			return network, nil
+		}
+	}
+	return NetworkUnknown, fmt.Errorf("Unknown network %s", name)
+}
+
+// HostNetwork classifies a host as written in a bitnode file: an IP address
+// or an onion or I2P name. A dotted quad is IPv4; only an IPv4-mapped
+// address written as such, e.g. ::ffff:1.2.3.4, follows MappedAsIPv6.
+func HostNetwork(host string) Network {
+	host = strings.ToLower(host)
+	if strings.HasSuffix(host, ".onion") {
+		return NetworkTor
+	}
+	if strings.HasSuffix(host, ".i2p") {
+		return NetworkI2P
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return NetworkUnknown
+	}
+	if ip.To4() != nil {
+		if MappedAsIPv6 && strings.Contains(host, ":") {
+			return NetworkIPv6
+		}
+		return NetworkIPv4
+	}
+	return CService{IPAddress: ip}.Network()
+}
+
+// NormalizeHostKey reduces a host, with or without a port, to the key used
+// to match addresses between peers.dat and bitnode files: IPs in their
+// canonical form with IPv4-mapped IPv6 as plain IPv4, IPv6 unbracketed, and
+// onion names lowercased. Unrecognised hosts are returned lowercased.
+func NormalizeHostKey(s string) string {
+	host := strings.TrimSpace(s)
+	if ip := net.ParseIP(host); ip == nil {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	if NormalizeOnionNames {
+		return NormalizeOnion(host)
+	}
+	return strings.ToLower(host)
+}
+
+// NormalizeOnionNames has NormalizeHostKey canonicalize onion names with
+// NormalizeOnion, so that the forms found in different files match
+var NormalizeOnionNames = false
+
+// NormalizeOnion canonicalizes an onion service name: lowercased, without a
+// trailing dot and with the .onion suffix, which bare v2 (16 character) and
+// v3 (56 character) base32 names are given. Other names are only lowercased.
+// A v2 and a v3 name are different keys and can't be matched to one another.
+func NormalizeOnion(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if strings.HasSuffix(host, ".onion") {
+		return host
+	}
+	if len(host) == 16 || len(host) == 56 {
+		if _, err := onionEncoding.DecodeString(strings.ToUpper(host)); err == nil {
+			return host + ".onion"
+		}
+	}
+	return host
+}
+
+// NormalizeEndpointKey is NormalizeHostKey keeping the port, giving
+// host:port with IPv6 hosts bracketed. A string without a port is reduced to
+// its host.
+func NormalizeEndpointKey(s string) string {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(s))
+	if err != nil {
+		return NormalizeHostKey(s)
+	}
+	return net.JoinHostPort(NormalizeHostKey(host), port)
+}
+
+// Tor v2 addresses are stored in the legacy 16 byte format behind the
+// OnionCat prefix fd87:d87e:eb43::/48
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// Core's internal addresses, generated for seeding and never connected to,
+// sit behind fd6b:88c0:8724::/48
+var internalPrefix = []byte{0xfd, 0x6b, 0x88, 0xc0, 0x87, 0x24}
+
+// MappedAsIPv6 classifies IPv4-mapped IPv6 addresses (::ffff:0:0/96) as IPv6
+// rather than IPv4. The default, false, matches Core. It only applies to
+// entries read in the legacy 16 byte format, which can't tell a mapped
+// address from IPv4: setting it turns all of a legacy file's IPv4 entries
+// into IPv6. Addresses decoded from addrv2 as BIP155 IPv4 and dotted quad
+// bitnode hosts stay IPv4, so their matches agree; see HostNetwork.
+var MappedAsIPv6 = false
+
+// BIP155Network is the network id an address is tagged with in the addrv2
+// format. Unlike Network it tells Tor v2 and v3 apart.
+type BIP155Network uint8
+
+const (
+	BIP155Legacy BIP155Network = 0 // not tagged: read in the legacy format
+	BIP155IPv4   BIP155Network = 1
+	BIP155IPv6   BIP155Network = 2
+	BIP155TorV2  BIP155Network = 3
+	BIP155TorV3  BIP155Network = 4
+	BIP155I2P    BIP155Network = 5
+	BIP155CJDNS  BIP155Network = 6
+)
+
+var bip155Names = map[BIP155Network]string{
+	BIP155Legacy: "legacy",
+	BIP155IPv4:   "ipv4",
+	BIP155IPv6:   "ipv6",
+	BIP155TorV2:  "torv2",
+	BIP155TorV3:  "torv3",
+	BIP155I2P:    "i2p",
+	BIP155CJDNS:  "cjdns",
+}
+
+func (id BIP155Network) String() string {
+	if name, ok := bip155Names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(id))
+}
+
+var bip155Networks = map[BIP155Network]Network{
+	BIP155IPv4:  NetworkIPv4,
+	BIP155IPv6:  NetworkIPv6,
+	BIP155TorV2: NetworkTor,
+	BIP155TorV3: NetworkTor,
+	BIP155I2P:   NetworkI2P,
+	BIP155CJDNS: NetworkCJDNS,
+}
+
+// ClassifyNetwork determines the network of a service. Addresses decoded
+// from addrv2 carry their BIP155 network id; legacy 16 byte addresses are
+// classified by prefix, IPv4-mapped as IPv4 unless MappedAsIPv6 is set and
+// the OnionCat and internal ranges as Tor and Internal.
+func ClassifyNetwork(cService CService) Network {
+	if network, ok := bip155Networks[cService.NetworkID]; ok {
+		return network
+	}
+
+	ip := cService.IPAddress
+	if len(ip) != net.IPv6len && len(ip) != net.IPv4len {
+		return NetworkUnknown
+	}
+	if ip.To4() != nil {
+		if MappedAsIPv6 && len(ip) == net.IPv6len {
+			return NetworkIPv6
+		}
+		return NetworkIPv4
+	}
+	if bytes.HasPrefix(ip, onionCatPrefix) {
+		return NetworkTor
+	}
+	if bytes.HasPrefix(ip, internalPrefix) {
+		return NetworkInternal
+	}
+	return NetworkIPv6
+}
+
+// Network classifies the service, see ClassifyNetwork
+func (cService CService) Network() Network {
+	return ClassifyNetwork(cService)
+}
+
+var onionEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Host returns the service's host without the port: a dotted quad for IPv4,
+// the textual form for IPv6 and CJDNS, the .onion name for Tor and the
+// .b32.i2p name for I2P
+func (cService CService) Host() string {
+	switch cService.Network() {
+	case NetworkTor:
+		if cService.NetworkID == BIP155TorV3 {
+			return torV3Name(cService.IPAddress)
+		}
+		name := onionEncoding.EncodeToString(cService.IPAddress[len(onionCatPrefix):])
+		return strings.ToLower(name) + ".onion"
+	case NetworkI2P:
+		return strings.ToLower(onionEncoding.EncodeToString(cService.IPAddress)) + ".b32.i2p"
+	case NetworkUnknown:
+		return hexstring(cService.IPAddress)
+	}
+	return cService.IPAddress.String()
+}
+
+// torV3Name encodes a Tor v3 ed25519 public key as its onion name, as in
+// rend-spec-v3: base32(pubkey | checksum | version), the checksum being the
+// first two bytes of SHA3-256(".onion checksum" | pubkey | version)
+func torV3Name(pubkey []byte) string {
+	const version = 3
+	hash := sha3.Sum256(append(append([]byte(".onion checksum"), pubkey...), version))
+	name := append(append(append([]byte{}, pubkey...), hash[:2]...), version)
+	return strings.ToLower(onionEncoding.EncodeToString(name)) + ".onion"
+}
+
+// Key returns host:port with IPv6 hosts bracketed, identifying a service
+// uniquely across tables and files
+func (cService CService) Key() string {
+	return net.JoinHostPort(cService.Host(), strconv.Itoa(int(cService.Port)))
+}
+
+// TCPAddr returns the entry's address for dialing with the net package.
+// IPv4, IPv6 and CJDNS addresses, the last being IPv6 addresses on the
+// cjdns interface, convert; Tor and I2P names need a proxy and, like
+// internal and unknown addresses, return an error.
+func (cAddrInfo CAddrInfo) TCPAddr() (*net.TCPAddr, error) {
+	service := cAddrInfo.Address.PeerAddress
+	switch network := service.Network(); network {
+	case NetworkIPv4, NetworkIPv6, NetworkCJDNS:
+		ip := service.IPAddress
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.TCPAddr{IP: append(net.IP{}, ip...), Port: int(service.Port)}, nil
+	default:
+		return nil, fmt.Errorf("Can't convert %s address %s to a TCP address", network, service.Host())
+	}
+}
+
+// Addr is TCPAddr as a net.Addr, for code taking the interface
+func (cAddrInfo CAddrInfo) Addr() (net.Addr, error) {
+	addr, err := cAddrInfo.TCPAddr()
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// documentation and benchmarking ranges which are never routable
+var unroutableNets = mustParseCIDRs(
+	"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", // RFC5737
+	"198.18.0.0/15", // RFC2544
+	"100.64.0.0/10", // RFC6598
+	"2001:db8::/32", // RFC3849
+	"2001:10::/28",  // RFC4843
+	"2001:20::/28",  // RFC7343
+	"fe80::/64",     // RFC4862
+)
+
+// IsRoutable reports whether the service could be reached over the public
+// internet, mirroring Bitcoin Core's CNetAddr::IsRoutable
+func (cService CService) IsRoutable() bool {
+	ip := cService.IPAddress
+	switch cService.Network() {
+	case NetworkTor, NetworkI2P, NetworkCJDNS:
+		return true
+	case NetworkUnknown, NetworkInternal:
+		return false
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || ip4.Equal(net.IPv4bcast)) {
+		return false
+	}
+	for _, n := range unroutableNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHostKey(t *testing.T) {
+	for input, want := range map[string]string{
+		"1.2.3.4":                   "1.2.3.4",
+		" 1.2.3.4\t":                "1.2.3.4",
+		"1.2.3.4:8333":              "1.2.3.4",
+		"::ffff:1.2.3.4":            "1.2.3.4",
+		"[::ffff:1.2.3.4]:8333":     "1.2.3.4",
+		"2001:DB8::1":               "2001:db8::1",
+		"2001:db8:0:0:0:0:0:1":      "2001:db8::1",
+		"[2001:db8::1]":             "2001:db8::1",
+		"[2001:db8::1]:8333":        "2001:db8::1",
+		"expyuzz4wqqyqhjn.onion":    "expyuzz4wqqyqhjn.onion",
+		"EXPYUZZ4WQQYQHJN.ONION:80": "expyuzz4wqqyqhjn.onion",
+	} {
+		if got := NormalizeHostKey(input); got != want {
+			t.Errorf("NormalizeHostKey(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// both sides of a match must produce the same key for the same address
+func TestHostKeysAgree(t *testing.T) {
+	onionV2, err := onionEncoding.DecodeString("EXPYUZZ4WQQYQHJN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, service := range map[string]CService{
+		"1.2.3.4":                {IPAddress: net.ParseIP("1.2.3.4").To16(), Port: 8333},
+		"::ffff:1.2.3.4":         {IPAddress: net.ParseIP("1.2.3.4").To16(), Port: 8333},
+		"[2001:db8::1]:8333":     {IPAddress: net.ParseIP("2001:db8::1"), Port: 8333},
+		"expyuzz4wqqyqhjn.onion": {IPAddress: append(append(net.IP{}, onionCatPrefix...), onionV2...), Port: 8333},
+	} {
+		if got, want := reachabilityKey(service), bitnodeKey(line); got != want {
+			t.Errorf("address %s keyed %q, bitnode line %q keyed %q", service, got, line, want)
+		}
+	}
+}
+
+func TestNormalizeEndpointKey(t *testing.T) {
+	for input, want := range map[string]string{
+		"1.2.3.4:8333":                "1.2.3.4:8333",
+		"[::ffff:1.2.3.4]:8333":       "1.2.3.4:8333",
+		"[2001:DB8::1]:18333":         "[2001:db8::1]:18333",
+		"EXPYUZZ4WQQYQHJN.onion:8333": "expyuzz4wqqyqhjn.onion:8333",
+		"1.2.3.4":                     "1.2.3.4",
+		"2001:db8::1":                 "2001:db8::1",
+	} {
+		if got := NormalizeEndpointKey(input); got != want {
+			t.Errorf("NormalizeEndpointKey(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	a := CService{IPAddress: net.ParseIP("1.2.3.4").To16(), Port: 8333}
+	b := CService{IPAddress: net.ParseIP("1.2.3.4").To16(), Port: 8334}
+	if NormalizeEndpointKey(a.Key()) == NormalizeEndpointKey(b.Key()) {
+		t.Error("the same IP on different ports has the same endpoint key")
+	}
+}
+
+func TestClassifyNetwork(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		service CService
+		want    Network
+	}{
+		{"legacy IPv4", CService{IPAddress: net.ParseIP("1.2.3.4").To16()}, NetworkIPv4},
+		{"4 byte IPv4", CService{IPAddress: net.ParseIP("1.2.3.4").To4()}, NetworkIPv4},
+		{"legacy IPv6", CService{IPAddress: net.ParseIP("2001:db8::1")}, NetworkIPv6},
+		{"legacy Tor v2", CService{IPAddress: append(append(net.IP{}, onionCatPrefix...), make([]byte, 10)...)}, NetworkTor},
+		{"internal", CService{IPAddress: append(append(net.IP{}, internalPrefix...), make([]byte, 10)...)}, NetworkInternal},
+		{"BIP155 IPv4", CService{IPAddress: net.ParseIP("1.2.3.4").To16(), NetworkID: BIP155IPv4}, NetworkIPv4},
+		{"BIP155 Tor v3", CService{IPAddress: make([]byte, 32), NetworkID: BIP155TorV3}, NetworkTor},
+		{"BIP155 I2P", CService{IPAddress: make([]byte, 32), NetworkID: BIP155I2P}, NetworkI2P},
+		{"BIP155 CJDNS", CService{IPAddress: net.ParseIP("fc00::1"), NetworkID: BIP155CJDNS}, NetworkCJDNS},
+		{"odd length", CService{IPAddress: make([]byte, 7)}, NetworkUnknown},
+	} {
+		if got := ClassifyNetwork(test.service); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	MappedAsIPv6 = true
+	defer func() { MappedAsIPv6 = false }()
+	if got := ClassifyNetwork(CService{IPAddress: net.ParseIP("1.2.3.4").To16()}); got != NetworkIPv6 {
+		t.Errorf("mapped IPv4 with MappedAsIPv6 set: got %s, want ipv6", got)
+	}
+	if got := ClassifyNetwork(CService{IPAddress: net.ParseIP("1.2.3.4").To16(), NetworkID: BIP155IPv4}); got != NetworkIPv4 {
+		t.Errorf("BIP155 IPv4 with MappedAsIPv6 set: got %s, want ipv4", got)
+	}
+}
+
+func TestMappedAsIPv6Stats(t *testing.T) {
+	MappedAsIPv6 = true
+	defer func() { MappedAsIPv6 = false }()
+
+	if got := HostNetwork("1.2.3.4"); got != NetworkIPv4 {
+		t.Errorf("dotted quad with MappedAsIPv6 set: got %s, want ipv4", got)
+	}
+	if got := HostNetwork("::ffff:1.2.3.4"); got != NetworkIPv6 {
+		t.Errorf("mapped literal with MappedAsIPv6 set: got %s, want ipv6", got)
+	}
+
+	// the addrv2 IPv4 entries and the crawl's dotted quads both stay IPv4,
+	// so the toggle doesn't cost them their matches
+	peersDB := parseFixture(t, mainnetFixture())
+	bitnodes := writeBitnodes(t, "1.2.3.4", "9.9.9.9", "2001:4860::1")
+	newResult, triedResult, err := ComputeStatsForNetwork(bitnodes, 1700000000, NetworkIPv4, peersDB.NewAddresses(), peersDB.TriedAddresses())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newResult.TotalIPs != 1 || newResult.NumberOfReachableIPs != 1 || newResult.SnapshotNetworkSize != 2 {
+		t.Errorf("new: got %d of %d reachable in a %d line snapshot, want 1 of 1 in 2", newResult.NumberOfReachableIPs, newResult.TotalIPs, newResult.SnapshotNetworkSize)
+	}
+	if triedResult.TotalIPs != 1 || triedResult.NumberOfReachableIPs != 1 {
+		t.Errorf("tried: got %d of %d reachable, want 1 of 1", triedResult.NumberOfReachableIPs, triedResult.TotalIPs)
+	}
+}
+
+func TestNetworkNames(t *testing.T) {
+	for network, want := range map[Network]string{
+		NetworkUnknown: "unknown", NetworkIPv4: "ipv4", NetworkIPv6: "ipv6", NetworkTor: "onion",
+		NetworkI2P: "i2p", NetworkCJDNS: "cjdns", NetworkInternal: "internal", Network(99): "unknown",
+	} {
+		if got := network.String(); got != want {
+			t.Errorf("Network(%d).String() = %q, want %q", network, got, want)
+		}
+		if network != NetworkUnknown && want != "unknown" {
+			if parsed, err := ParseNetwork(strings.ToUpper(want)); err != nil || parsed != network {
+				t.Errorf("ParseNetwork(%q) = %s, %v", want, parsed, err)
+			}
+		}
+	}
+}
+
+func TestNormalizeOnion(t *testing.T) {
+	v3 := strings.TrimSuffix(torV3Name(bytes.Repeat([]byte{0x42}, 32)), ".onion")
+	for input, want := range map[string]string{
+		"expyuzz4wqqyqhjn":             "expyuzz4wqqyqhjn.onion",
+		"EXPYUZZ4WQQYQHJN":             "expyuzz4wqqyqhjn.onion",
+		"expyuzz4wqqyqhjn.onion":       "expyuzz4wqqyqhjn.onion",
+		"expyuzz4wqqyqhjn.onion.":      "expyuzz4wqqyqhjn.onion",
+		v3:                             v3 + ".onion",
+		strings.ToUpper(v3) + ".ONION": v3 + ".onion",
+		"not-an-onion-name":            "not-an-onion-name",
+		"expyuzz4wqqyqhj1":             "expyuzz4wqqyqhj1", // 1 isn't base32
+	} {
+		if got := NormalizeOnion(input); got != want {
+			t.Errorf("NormalizeOnion(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	// only with NormalizeOnionNames do bare names match suffixed ones
+	defer func() { NormalizeOnionNames = false }()
+	for normalize, want := range map[bool]bool{false: false, true: true} {
+		NormalizeOnionNames = normalize
+		if got := NormalizeHostKey("EXPYUZZ4WQQYQHJN") == NormalizeHostKey("expyuzz4wqqyqhjn.onion:8333"); got != want {
+			t.Errorf("normalize %t: v2 forms match %t, want %t", normalize, got, want)
+		}
+		if got := NormalizeHostKey(v3) == NormalizeHostKey(strings.ToUpper(v3)+".onion"); got != want {
+			t.Errorf("normalize %t: v3 forms match %t, want %t", normalize, got, want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputWriter receives the computed result of each table, letting results
+// be routed to any destination
+type OutputWriter interface {
+	WriteResult(table string, result *Result) error
+}
+
+// TablesWriter is implemented by writers taking the results of both tables
+// at once, which WriteOutput then hands them to instead
+type TablesWriter interface {
+	WriteResults(newResult, triedResult *Result) error
+}
+
+// WriteOutput hands the result of both tables to writer
+func WriteOutput(writer OutputWriter, newResult, triedResult *Result) error {
+	if tablesWriter, ok := writer.(TablesWriter); ok {
+		return tablesWriter.WriteResults(newResult, triedResult)
+	}
+	if err := writer.WriteResult("new", newResult); err != nil {
+		return err
+	}
+	return writer.WriteResult("tried", triedResult)
+}
+
+// CSVFileWriter writes each table's result as CSV to
+// <table>-table-stats.txt in BasePath
+type CSVFileWriter struct {
+	BasePath string
+}
+
+func (w CSVFileWriter) WriteResult(table string, result *Result) error {
+	return writeTableFile(filepath.Join(w.BasePath, table+"-table-stats.txt"), func(file io.Writer) error {
+		return WriteCSV(file, result)
+	})
+}
+
+// TSVFileWriter writes each table's result as tab separated values to
+// <table>-table-stats.txt in BasePath, with the same columns as CSVFileWriter
+type TSVFileWriter struct {
+	BasePath string
+}
+
+func (w TSVFileWriter) WriteResult(table string, result *Result) error {
+	return writeTableFile(filepath.Join(w.BasePath, table+"-table-stats.txt"), func(file io.Writer) error {
+		return WriteTSV(file, result)
+	})
+}
+
+// JSONFileWriter writes each table's result as a ResultDocument to
+// <table>-table-stats.json in BasePath
+type JSONFileWriter struct {
+	BasePath string
+}
+
+func (w JSONFileWriter) WriteResult(table string, result *Result) error {
+	return writeTableFile(filepath.Join(w.BasePath, table+"-table-stats.json"), func(file io.Writer) error {
+		encoder := json.NewEncoder(file)
+		if prettyJSON {
+			encoder.SetIndent("", "  ")
+		}
+		return encoder.Encode(NewResultDocument(table, result))
+	})
+}
+
+// CombinedJSONFileWriter writes the results of both tables as one
+// StatsDocument to table-stats.json in BasePath. It only writes through
+// WriteOutput.
+type CombinedJSONFileWriter struct {
+	BasePath string
+}
+
+func (w CombinedJSONFileWriter) WriteResult(table string, result *Result) error {
+	return fmt.Errorf("The json-combined output needs both tables at once")
+}
+
+func (w CombinedJSONFileWriter) WriteResults(newResult, triedResult *Result) error {
+	return writeTableFile(filepath.Join(w.BasePath, "table-stats.json"), func(file io.Writer) error {
+		encoder := json.NewEncoder(file)
+		if prettyJSON {
+			encoder.SetIndent("", "  ")
+		}
+		return encoder.Encode(NewStatsDocument(newResult, triedResult))
+	})
+}
+
+// MultiWriter hands each result to every one of its writers in turn,
+// stopping at the first error
+type MultiWriter []OutputWriter
+
+func (writers MultiWriter) WriteResult(table string, result *Result) error {
+	for _, writer := range writers {
+		if err := writer.WriteResult(table, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (writers MultiWriter) WriteResults(newResult, triedResult *Result) error {
+	for _, writer := range writers {
+		if err := WriteOutput(writer, newResult, triedResult); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewOutputWriter returns the writer for a comma separated list of formats
+// {csv|tsv|json|json-combined}, writing into basePath. json writes a file per
+// table and json-combined one for both. csv and tsv share a file name and so
+// can't be combined.
+func NewOutputWriter(formats, basePath string) (OutputWriter, error) {
+	var writers MultiWriter
+	seen := make(map[string]bool)
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.TrimSpace(format)
+		if seen[format] {
+			continue
+		}
+		seen[format] = true
+
+		switch format {
+		case "csv":
+			writers = append(writers, CSVFileWriter{BasePath: basePath})
+		case "tsv":
+			writers = append(writers, TSVFileWriter{BasePath: basePath})
+		case "json":
+			writers = append(writers, JSONFileWriter{BasePath: basePath})
+		case "json-combined":
+			writers = append(writers, CombinedJSONFileWriter{BasePath: basePath})
+		default:
+			return nil, fmt.Errorf("Invalid output format %s", format)
+		}
+	}
+	if seen["csv"] && seen["tsv"] {
+		return nil, fmt.Errorf("Output formats csv and tsv can't be combined")
+	}
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return writers, nil
+}
+
+// entriesFormat picks the delimited format used for entry listings from a
+// list of output formats, csv unless tsv is asked for
+func entriesFormat(formats string) string {
+	for _, format := range strings.Split(formats, ",") {
+		if strings.TrimSpace(format) == "tsv" {
+			return "tsv"
+		}
+	}
+	return "csv"
+}
+
+// writeTableFile creates the output file at path and fills it with write
+func writeTableFile(path string, write func(io.Writer) error) error {
+	file, err := CreateOutputFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteCSV writes the CSV header and the row for result
+func WriteCSV(w io.Writer, result *Result) error {
+	return writeDelimited(w, ",", csvHeader(result), csvRow(result))
+}
+
+// tsvEscaper escapes the characters which would break a TSV row, in the
+// style of the IANA text/tab-separated-values convention
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// WriteTSV writes the header and the row for result separated by tabs
+func WriteTSV(w io.Writer, result *Result) error {
+	escape := func(fields []string) []string {
+		escaped := make([]string, len(fields))
+		for i, field := range fields {
+			escaped[i] = tsvEscaper.Replace(field)
+		}
+		return escaped
+	}
+	return writeDelimited(w, "\t", escape(csvHeader(result)), escape(csvRow(result)))
+}
+
+func writeDelimited(w io.Writer, delimiter string, rows ...[]string) error {
+	for _, row := range rows {
+		if _, err := io.WriteString(w, strings.Join(row, delimiter)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader returns the columns of result's row, led by Node_ID when the
+// result is labelled
+func csvHeader(result *Result) []string {
+	var header []string
+	if result.Label != "" {
+		header = append(header, "Node_ID")
+	}
+	header = append(header, "Approx_Peerdat_Date", "Oldest_IP_Days", "Total_IPs", "PercentReachable")
+	for _, bucket := range result.Age.Buckets() {
+		header = append(header, bucket.Label)
+	}
+	header = append(header, "Approx_Peerdat_Epoch", "Approx_Peerdat_Time", "Oldest_IP_Epoch", "Oldest_IP_Time")
+
+	for _, flag := range MajorServices {
+		header = append(header, "Reach_"+ServiceName(flag))
+	}
+	header = append(header, "P2P_V2_Count", "P2P_V2_Percent", "Snapshot_Network_Size", "Terrible_IPs", "Newest_IP_Epoch", "Span_Days")
+	for _, network := range ReportedNetworks {
+		header = append(header, "Net_"+network.String()+"_IPs", "Net_"+network.String()+"_Reach")
+	}
+
+	if cumulative {
+		for _, bucket := range result.Age.Buckets() {
+			header = append(header, "Pct_"+bucket.Label)
+		}
+		for _, bucket := range result.Age.Buckets() {
+			header = append(header, "Cum_"+bucket.Label)
+		}
+	}
+	return header
+}
+
+func csvRow(result *Result) []string {
+	approxAgeT := time.Unix(int64(result.ApproxAge), 0)
+	approxAgeStr := approxAgeT.Format(timeFormat)
+
+	totalIPs := strconv.Itoa(result.TotalIPs)
+	percent := formatPercent(result.Percentage)
+	if result.NoCrawlData {
+		percent = "NA"
+	}
+
+	// raw epochs and RFC3339 times keep the intraday precision the
+	// date column loses
+	approxEpoch := strconv.FormatUint(uint64(result.ApproxAge), 10)
+	approxTime := isoTime(result.ApproxAge)
+
+	// an empty table has no oldest entry
+	daysOldestIP, oldestEpoch, oldestTime := "NA", "NA", "NA"
+	if result.TotalIPs > 0 {
+		daysOldestIP = strconv.Itoa((int(result.Reference) - int(result.OldestIPAge)) / ONE_DAY)
+		oldestEpoch = strconv.FormatUint(uint64(result.OldestIPAge), 10)
+		oldestTime = isoTime(result.OldestIPAge)
+	}
+
+	var row []string
+	if result.Label != "" {
+		row = append(row, result.Label)
+	}
+	row = append(row, approxAgeStr, daysOldestIP, totalIPs, percent)
+	for _, bucket := range result.Age.Buckets() {
+		row = append(row, strconv.Itoa(bucket.Count))
+	}
+	row = append(row, approxEpoch, approxTime, oldestEpoch, oldestTime)
+	for _, service := range result.Services {
+		servicePercent := formatPercent(service.Percentage())
+		if result.NoCrawlData {
+			servicePercent = "NA"
+		}
+		row = append(row, servicePercent)
+	}
+	row = append(row, strconv.Itoa(result.P2PV2Count), formatPercent(result.P2PV2Percentage()), strconv.Itoa(result.SnapshotNetworkSize), strconv.Itoa(result.Terrible))
+
+	// an empty table has no extent
+	newestEpoch, spanDays := "NA", "NA"
+	if result.TotalIPs > 0 {
+		newestEpoch = strconv.FormatUint(uint64(result.NewestTime), 10)
+		spanDays = strconv.FormatFloat(result.SpanDays, 'f', 2, 64)
+	}
+	row = append(row, newestEpoch, spanDays)
+
+	// reachability can't be told for a network the crawl didn't cover
+	for _, network := range result.Networks {
+		networkPercent := formatPercent(network.Percentage())
+		if result.NoCrawlData || !network.Crawled {
+			networkPercent = "NA"
+		}
+		row = append(row, strconv.Itoa(network.Total), networkPercent)
+	}
+	if cumulative {
+		for _, share := range append(result.AgeDistribution(), result.CumulativeAgeDistribution()...) {
+			row = append(row, formatPercent(share))
+		}
+	}
+	return row
+}
+
+var entryColumns = []string{"Address", "Network", "Port", "Services", "Time", "Source", "Last_Success", "Attempts"}
+
+func entryRow(info CAddrInfo) []string {
+	address := info.Address
+	return []string{
+		address.PeerAddress.Host(),
+		address.PeerAddress.Network().String(),
+		strconv.Itoa(int(address.PeerAddress.Port)),
+		strconv.FormatUint(address.Services(), 10),
+		strconv.FormatUint(uint64(address.Time), 10),
+		info.SourceService().Host(),
+		strconv.FormatUint(info.LastSuccess, 10),
+		strconv.FormatUint(uint64(info.Attempts), 10),
+	}
+}
+
+// WriteEntries writes one row per entry with its full metadata, in the
+// given output format
+func WriteEntries(w io.Writer, format string, infos []CAddrInfo) error {
+	delimiter := ","
+	escape := func(field string) string { return field }
+	if format == "tsv" {
+		delimiter = "\t"
+		escape = tsvEscaper.Replace
+	}
+
+	rows := [][]string{entryColumns}
+	for _, info := range infos {
+		rows = append(rows, entryRow(info))
+	}
+	for _, row := range rows {
+		for i := range row {
+			row[i] = escape(row[i])
+		}
+	}
+	return writeDelimited(w, delimiter, rows...)
+}
+
+// WriteReachableEntries writes the reachable entries of a table to
+// <table>-table-reachable.txt in basePath
+func WriteReachableEntries(basePath, table, format string, infos []CAddrInfo, result *Result) error {
+	return writeTableFile(filepath.Join(basePath, table+"-table-reachable.txt"), func(file io.Writer) error {
+		return WriteEntries(file, format, ReachableEntries(infos, result))
+	})
+}
+
+// formatPercent formats a fraction as a percentage with -percent-precision
+// decimal places
+func formatPercent(fraction float64) string {
+	return strconv.FormatFloat(fraction*100, 'f', percentPrecision, 64)
+}
+
+// roundPercent rounds a fraction so that as a percentage it has
+// -percent-precision decimal places, for the JSON output to match the
+// others
+func roundPercent(fraction float64) float64 {
+	scale := math.Pow(10, float64(percentPrecision+2))
+	return math.Round(fraction*scale) / scale
+}
+
+// isoTime formats a unix timestamp as RFC3339 in UTC
+func isoTime(ts uint32) string {
+	return time.Unix(int64(ts), 0).UTC().Format(time.RFC3339)
+}
+
+// gzipFile closes the gzip stream before the file underneath it
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.file.Close()
+		return err
+	}
+	return g.file.Close()
+}
+
+// CreateOutputFile creates an output file, gzip compressing it when the
+// -compress flag is set
+func CreateOutputFile(path string) (io.WriteCloser, error) {
+	if !compressOutput {
+		return os.Create(path)
+	}
+
+	file, err := os.Create(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	return gzipFile{gzip.NewWriter(file), file}, nil
+}
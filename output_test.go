@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEmptyTableHasNoOldestEntry(t *testing.T) {
+	newResult, triedResult, err := ComputeStats(writeBitnodes(t, "1.2.0.1"), 1700000000, []CAddrInfo{addrInfo("1.2.0.1", 8333, 1700000000, NodeNetwork)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, row := csvHeader(triedResult), csvRow(triedResult)
+	for i, column := range header {
+		switch column {
+		case "Oldest_IP_Days", "Oldest_IP_Epoch", "Oldest_IP_Time", "Newest_IP_Epoch", "Span_Days":
+			if row[i] != "NA" {
+				t.Errorf("%s of an empty table is %s, want NA", column, row[i])
+			}
+		}
+	}
+
+	data, err := json.Marshal(NewResultDocument("tried", triedResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"oldest_ip":null`, `"oldest_ip_days":null`, `"newest_ip":null`, `"span_days":null`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("JSON of an empty table lacks %s", field)
+		}
+	}
+
+	stats := NewResultDocument("new", newResult).Stats
+	if stats.OldestIP == nil || *stats.OldestIP != 1700000000 || *stats.OldestIPDays != 0 {
+		t.Errorf("got oldest %v, %v days for a table of one current entry", stats.OldestIP, stats.OldestIPDays)
+	}
+}
+
+func TestJSONPercentagePrecision(t *testing.T) {
+	defer func(precision int) { percentPrecision = precision }(percentPrecision)
+	result := CreateResult()
+	result.Percentage = 2.0 / 3
+
+	for precision, want := range map[int]float64{0: 0.67, 2: 0.6667, 4: 0.666667} {
+		percentPrecision = precision
+		if got := NewResultDocument("new", result).Stats.Percentage; got != want {
+			t.Errorf("precision %d: got %v, want %v", precision, got, want)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 )
@@ -19,6 +24,16 @@ type PeersDB struct {
 	NewBuckets    uint32      `json:"new_buckets"`   // 49 : 4
 	NewAddrInfo   []CAddrInfo `json:"new_addr_info"`
 	TriedAddrInfo []CAddrInfo `json:"tried_addr_info"`
+
+	// NewBucketEntries is the new table's bucket layout, only read if
+	// RetainBuckets is set
+	NewBucketEntries []BucketEntry `json:"new_bucket_entries,omitempty"`
+
+	// the raw bucket layout following the tables and a digest of the
+	// tables it indexes, for Serialize to write back while they're unchanged
+	layout        []byte
+	tablesDigest  [32]byte
+	checksumValid bool
 }
 
 type CAddrInfo struct {
@@ -26,6 +41,17 @@ type CAddrInfo struct {
 	Source      net.IP   `json:"source"`
 	LastSuccess uint64   `json:"last_success"`
 	Attempts    uint32   `json:"attempts"`
+
+	// SourceNetworkID is the BIP155 network of Source in addrv2 entries,
+	// telling a Tor v3 source from an I2P one, see SourceService
+	SourceNetworkID BIP155Network `json:"-"`
+
+	// BucketIndex and BucketPosition place a new table entry in the bucket
+	// the file's layout first references it in. They are -1 for tried
+	// entries, whose placement peers.dat doesn't record, and when the
+	// layout is missing or won't be used by Core.
+	BucketIndex    int `json:"bucket_index"`
+	BucketPosition int `json:"bucket_position"`
 }
 
 type CAddress struct {
@@ -38,8 +64,47 @@ type CAddress struct {
 type CService struct {
 	IPAddress net.IP
 	Port      uint16 // This is serialized as BigEndian
+
+	// NetworkID is the BIP155 network the address was tagged with in the
+	// addrv2 format, BIP155Legacy for addresses read in the legacy format
+	// whose network follows from their bytes. Network() classifies both.
+	NetworkID BIP155Network
+}
+
+// Magic returns the network magic the file starts with, telling mainnet,
+// testnet, signet and regtest files apart
+func (peersDB PeersDB) Magic() (magic [4]byte) {
+	copy(magic[:], peersDB.MessageBytes)
+	return
 }
 
+// NetworkName returns the name of the chain the network magic belongs to,
+// mainnet, testnet3, signet or regtest, and "unknown" for any other magic,
+// which only a leniently parsed file can have
+func (peersDB PeersDB) NetworkName() string {
+	if chain, ok := ChainForMagic(peersDB.MessageBytes); ok {
+		return chain.Name
+	}
+	return "unknown"
+}
+
+// NodeKey returns the secret addrman keys its bucket placement with. It is
+// generated once per node, so two files with the same key were written by
+// the same node.
+func (peersDB PeersDB) NodeKey() (key [32]byte) {
+	copy(key[:], peersDB.NKey)
+	return
+}
+
+// SameNode reports whether both files were written by the same node, going
+// by their node keys
+func (peersDB PeersDB) SameNode(other PeersDB) bool {
+	return len(peersDB.NKey) != 0 && peersDB.NodeKey() == other.NodeKey()
+}
+
+// NewPeersDB parses the peers file at path. A truncated file returns an
+// error wrapping ErrTruncated, naming the entry and byte offset
+// where parsing stopped, along with whatever was parsed before it.
 func NewPeersDB(path string) (PeersDB, error) {
 	peersDB := PeersDB{
 		Path: path,
@@ -47,64 +112,356 @@ func NewPeersDB(path string) (PeersDB, error) {
 
 	dbbytes, err := readDBBytes(peersDB)
 	if err != nil {
-		return peersDB, fmt.Errorf("Couldn't read peer file %s", peersDB.Path)
+		return peersDB, fmt.Errorf("Couldn't read peer file %s: %w", peersDB.Path, err)
+	}
+
+	return parsePeersDB(peersDB, dbbytes)
+}
+
+// NewPeersDBMapped parses the peers file like NewPeersDB but maps it into
+// memory instead of reading it onto the heap, which helps when many large
+// files are parsed at once. The parsed byte fields point into the mapping,
+// so the PeersDB must not be used after calling release. Release is also
+// returned with a partially parsed PeersDB. mmap is used on unix platforms;
+// elsewhere the file is read normally and release does nothing.
+func NewPeersDBMapped(path string) (peersDB PeersDB, release func() error, err error) {
+	peersDB.Path = path
+
+	dbbytes, release, err := mapFile(path)
+	if err != nil {
+		return peersDB, nil, fmt.Errorf("Couldn't read peer file %s: %w", peersDB.Path, err)
+	}
+
+	// a compressed file has to be decompressed onto the heap after all
+	if isGzip(dbbytes) {
+		data, err := gunzip(dbbytes)
+		release()
+		release = func() error { return nil }
+		if err != nil {
+			return peersDB, release, fmt.Errorf("Couldn't read peer file %s: %w", peersDB.Path, err)
+		}
+		dbbytes = data
+	}
+
+	peersDB, err = parsePeersDB(peersDB, dbbytes)
+	return peersDB, release, err
+}
+
+// NewPeersDBWithXorKey parses a peers file whose contents have been xor'd
+// with an obfuscation key, as Bitcoin Core does for its block files. The key
+// is repeated over the whole file.
+func NewPeersDBWithXorKey(path string, key []byte) (PeersDB, error) {
+	peersDB := PeersDB{
+		Path: path,
+	}
+
+	dbbytes, err := readDBBytes(peersDB)
+	if err != nil {
+		return peersDB, fmt.Errorf("Couldn't read peer file %s: %w", peersDB.Path, err)
+	}
+
+	if len(key) > 0 {
+		for i := range dbbytes {
+			dbbytes[i] ^= key[i%len(key)]
+		}
+	}
+
+	if len(dbbytes) < 4 || !isKnownMagic(dbbytes[:4]) {
+		return peersDB, fmt.Errorf("%w in %s after deobfuscation, is the xor key correct?", ErrBadMagic, peersDB.Path)
+	}
+
+	return parsePeersDB(peersDB, dbbytes)
+}
+
+// NewPeersDBLenient parses a possibly damaged peers file, salvaging what it
+// can. A record is considered undecodable when its serialization version
+// differs from that of the first record, as Core writes every record with
+// the same version, and is then skipped. Legacy records are a fixed size, so
+// parsing resumes at the next record boundary; addrv2 records aren't, and
+// there is no resync after a bad one: the records following it are read
+// from wherever it appeared to end. A record cut short by the end of the
+// file ends the parse. The offsets of skipped records are returned, for the
+// caller to report, and NNew and NTried keep the counts declared in the
+// header. The checksum isn't enforced, see VerifyChecksum.
+func NewPeersDBLenient(path string) (PeersDB, []uint64, error) {
+	peersDB := PeersDB{
+		Path: path,
+	}
+
+	dbbytes, err := readDBBytes(peersDB)
+	if err != nil {
+		return peersDB, nil, fmt.Errorf("Couldn't read peer file %s: %w", peersDB.Path, err)
+	}
+
+	if len(dbbytes) < peersHeaderSize {
+		return peersDB, nil, fmt.Errorf("Peer file %s is too short to hold a header", peersDB.Path)
 	}
 
 	dbreader := DBReader{
 		Bytes:  dbbytes,
 		Cursor: 0,
 	}
+	dbreader.readHeader(&peersDB)
+	peersDB.checksumValid = checksumMatches(dbbytes)
 
-	peersDB.MessageBytes = dbreader.readBytes(4)
-	peersDB.Version = dbreader.readUint8()
-	peersDB.KeySize = dbreader.readUint8()
-	peersDB.NKey = dbreader.readBytes(32)                  // uint256 type
-	peersDB.NNew = dbreader.readUint32()                   // int type
-	peersDB.NTried = dbreader.readUint32()                 // int type
-	peersDB.NewBuckets = dbreader.readUint32() ^ (1 << 30) // int type
+	var skipped []uint64
+	var version []byte
+	truncated := false
+	salvage := func(count uint32) []CAddrInfo {
+		var infos []CAddrInfo
+		var i uint32
+		for i = 0; i < count && !truncated; i++ {
+			offset := dbreader.Cursor
+			if _, ok := dbreader.peekCAddrInfoSize(); !ok {
+				skipped = append(skipped, offset)
+				truncated = true
+				return infos
+			}
 
-	peersDB.NewAddrInfo = make([]CAddrInfo, peersDB.NNew)
-	peersDB.TriedAddrInfo = make([]CAddrInfo, peersDB.NTried)
+			info := dbreader.readCAddrInfo()
+			if version == nil {
+				version = info.Address.SerializationVersion
+			}
+			if !bytes.Equal(info.Address.SerializationVersion, version) {
+				skipped = append(skipped, offset)
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return infos
+	}
 
-	var i uint32
-	for i = 0; i < peersDB.NNew; i++ {
-		peersDB.NewAddrInfo[i] = dbreader.readCAddrInfo()
+	peersDB.NewAddrInfo = salvage(peersDB.NNew)
+	peersDB.TriedAddrInfo = salvage(peersDB.NTried)
+
+	return peersDB, skipped, nil
+}
+
+// sizes of the fixed layout: header fields before the first record, and a
+// CAddrInfo serialized in the legacy format
+const peersHeaderSize = 4 + 1 + 1 + 32 + 4 + 4 + 4
+const cAddrInfoSize = 4 + 4 + 8 + 16 + 2 + 16 + 8 + 4
+
+// Errors the parsers wrap, so that callers can tell failures apart with
+// errors.Is
+var (
+	// ErrBadMagic is returned for a file of no known network
+	ErrBadMagic = errors.New("unknown network magic")
+	// ErrUnsupportedVersion is returned for a format version newer than
+	// the parser implements and not backwards compatible with it
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	// ErrChecksumMismatch is returned when a file's contents don't hash to
+	// its trailing checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrTruncated is returned for a file ending early. It is
+	// io.ErrUnexpectedEOF, so checks against either match.
+	ErrTruncated = io.ErrUnexpectedEOF
+	// ErrImplausibleCount is returned when the header declares more
+	// entries than addrman can hold
+	ErrImplausibleCount = errors.New("implausible address count")
+)
+
+// addrman's capacity: 1024 new and 256 tried buckets of 64 entries each
+const maxNew = 1024 * 64
+const maxTried = 256 * 64
+
+// checkCounts rejects header counts a corrupt file could declare, before
+// anything is allocated for them
+func checkCounts(peersDB PeersDB) error {
+	if peersDB.NNew > maxNew || peersDB.NTried > maxTried {
+		return fmt.Errorf("%w: %d new and %d tried entries exceed addrman's capacity", ErrImplausibleCount, peersDB.NNew, peersDB.NTried)
+	}
+	return nil
+}
+
+// parsePeersDB parses the header and both tables. Files of a network other
+// than mainnet, testnet3, signet and regtest are rejected with ErrBadMagic,
+// and incompatible formats with ErrUnsupportedVersion. A file ending early,
+// as when a node crashed while writing it, gives an error wrapping
+// ErrTruncated along with the header and the entries read so far. A complete
+// file failing its checksum gives ErrChecksumMismatch along with everything
+// parsed.
+func parsePeersDB(peersDB PeersDB, dbbytes []byte) (PeersDB, error) {
+	dbreader := DBReader{
+		Bytes:  dbbytes,
+		Cursor: 0,
+	}
+
+	if len(dbbytes) < peersHeaderSize {
+		return peersDB, fmt.Errorf("%w parsing header at byte offset %d", ErrTruncated, len(dbbytes))
+	}
+	dbreader.readHeader(&peersDB)
+	if !isKnownMagic(peersDB.MessageBytes) {
+		return peersDB, fmt.Errorf("%w %s in %s", ErrBadMagic, hexstring(peersDB.MessageBytes), peersDB.Path)
+	}
+	if err := checkVersion(peersDB); err != nil {
+		return peersDB, fmt.Errorf("Couldn't parse %s: %w", peersDB.Path, err)
 	}
 
-	for i = 0; i < peersDB.NTried; i++ {
-		peersDB.TriedAddrInfo[i] = dbreader.readCAddrInfo()
+	if err := checkCounts(peersDB); err != nil {
+		return peersDB, err
 	}
 
+	// before parsing rearranges the bytes
+	peersDB.checksumValid = checksumMatches(dbbytes)
+
+	// grow the tables as entries are read rather than trusting the header
+	var err error
+	if peersDB.NewAddrInfo, err = dbreader.readTable("new", peersDB.NNew); err != nil {
+		return peersDB, err
+	}
+	if peersDB.TriedAddrInfo, err = dbreader.readTable("tried", peersDB.NTried); err != nil {
+		return peersDB, err
+	}
+
+	// everything between the tables and the checksum is the bucket layout.
+	// The digest is taken over the tables as Serialize encodes them since
+	// parsing rearranges some fields in place.
+	if end := uint64(len(dbbytes)) - checksumSize; uint64(len(dbbytes)) >= checksumSize && end >= dbreader.Cursor {
+		if tables, err := peersDB.encodeTables(); err == nil {
+			peersDB.layout = append([]byte{}, dbbytes[dbreader.Cursor:end]...)
+			peersDB.tablesDigest = sha256.Sum256(tables)
+		}
+	}
+
+	// a damaged layout only fails the parse if it was asked for
+	entries, err := dbreader.readNewBuckets(peersDB)
+	if err != nil && RetainBuckets {
+		return peersDB, fmt.Errorf("Couldn't read bucket layout of %s: %s", peersDB.Path, err)
+	}
+	placeNewEntries(peersDB, entries)
+	if RetainBuckets {
+		peersDB.NewBucketEntries = entries
+	}
+
+	if !peersDB.checksumValid {
+		return peersDB, fmt.Errorf("%w: %s may be corrupt", ErrChecksumMismatch, peersDB.Path)
+	}
 	return peersDB, nil
 }
 
+// readTable reads count entries, stopping at the first which runs past the
+// end of the file
+func (dbreader *DBReader) readTable(table string, count uint32) ([]CAddrInfo, error) {
+	var infos []CAddrInfo
+	var i uint32
+	for i = 0; i < count; i++ {
+		if _, ok := dbreader.peekCAddrInfoSize(); !ok {
+			return infos, fmt.Errorf("%w parsing %s table entry %d at byte offset %d", ErrTruncated, table, i, dbreader.Cursor)
+		}
+		infos = append(infos, dbreader.readCAddrInfo())
+	}
+	return infos, nil
+}
+
+func (dbreader *DBReader) readHeader(peersDB *PeersDB) {
+	peersDB.MessageBytes = dbreader.readBytes(4)
+	peersDB.Version = dbreader.readUint8()
+	peersDB.KeySize = dbreader.readUint8()
+	peersDB.NKey = dbreader.readBytes(32)      // uint256 type
+	peersDB.NNew = dbreader.readUint32()       // int type
+	peersDB.NTried = dbreader.readUint32()     // int type
+	peersDB.NewBuckets = dbreader.readUint32() // int type
+	if peersDB.Version >= FormatDeterministic {
+		peersDB.NewBuckets ^= 1 << 30
+	}
+}
+
+// readCAddrInfo reads an entry in the legacy format or, as written since
+// Core 0.21, in addrv2 with the source address in addrv2 too
 func (dbreader *DBReader) readCAddrInfo() (cAddrInfo CAddrInfo) {
-	cAddrInfo.Address.SerializationVersion = dbreader.readBytes(4)
-	cAddrInfo.Address.Time = dbreader.readUint32()
-	cAddrInfo.Address.ServiceFlags = reverseBytes(dbreader.readBytes(8))
-	cAddrInfo.Address.PeerAddress.IPAddress = dbreader.readBytes(16)
-	cAddrInfo.Address.PeerAddress.Port = dbreader.readBigEndianUint16()
+	cAddrInfo.BucketIndex, cAddrInfo.BucketPosition = -1, -1
+	cAddrInfo.Address = dbreader.readCAddress()
 
-	cAddrInfo.Source = dbreader.readBytes(16)
+	if binary.LittleEndian.Uint32(cAddrInfo.Address.SerializationVersion)&addrV2Format != 0 {
+		cAddrInfo.SourceNetworkID, cAddrInfo.Source = dbreader.readAddrV2()
+	} else {
+		cAddrInfo.Source = dbreader.readBytes(16)
+	}
 	cAddrInfo.LastSuccess = dbreader.readUint64()
 	cAddrInfo.Attempts = dbreader.readUint32()
 	return
 }
 
+// Chain describes a bitcoin network a peers.dat can belong to
+type Chain struct {
+	Name        string
+	Magic       []byte
+	DefaultPort uint16
+}
+
+var knownChains = []Chain{
+	{"mainnet", []byte{0xf9, 0xbe, 0xb4, 0xd9}, 8333},
+	{"testnet3", []byte{0x0b, 0x11, 0x09, 0x07}, 18333},
+	{"signet", []byte{0x0a, 0x03, 0xcf, 0x40}, 38333},
+	{"regtest", []byte{0xfa, 0xbf, 0xb5, 0xda}, 18444},
+}
+
+// ChainForMagic returns the chain whose network magic is given
+func ChainForMagic(magic []byte) (Chain, bool) {
+	for _, chain := range knownChains {
+		if bytes.Equal(magic, chain.Magic) {
+			return chain, true
+		}
+	}
+	return Chain{}, false
+}
+
+func isKnownMagic(magic []byte) bool {
+	_, ok := ChainForMagic(magic)
+	return ok
+}
+
+// readDBBytes reads the file at the PeersDB's path, decompressing it if it
+// is gzip compressed
 func readDBBytes(peersDB PeersDB) ([]byte, error) {
-	return ioutil.ReadFile(peersDB.Path)
+	data, err := ioutil.ReadFile(peersDB.Path)
+	if err != nil || !isGzip(data) {
+		return data, err
+	}
+	return gunzip(data)
+}
+
+// readFile reads the file at path, with a no-op release for mapFile
+func readFile(path string) ([]byte, func() error, error) {
+	dbbytes, err := ioutil.ReadFile(path)
+	return dbbytes, func() error { return nil }, err
 }
 
 func (cAddrInfo CAddrInfo) String() string {
-	return fmt.Sprintf("%s\nSource: %s\nLastSuccess: %d\nAttempts: %d\n\n", cAddrInfo.Address, cAddrInfo.Source, cAddrInfo.LastSuccess, cAddrInfo.Attempts)
+	return fmt.Sprintf("%s\nSource: %s\nLastSuccess: %d\nAttempts: %d\n\n", cAddrInfo.Address, cAddrInfo.SourceService().Host(), cAddrInfo.LastSuccess, cAddrInfo.Attempts)
 }
 
 func (cAddress CAddress) String() string {
 	return fmt.Sprintf("SerializationVersion: %s\nTime: %d\nServiceFlags: 0x%s\nIP: %s", hexstring(cAddress.SerializationVersion), cAddress.Time, hexstring(cAddress.ServiceFlags), cAddress.PeerAddress)
 }
 
+// Services returns the advertised service bits as an integer
+func (cAddress CAddress) Services() uint64 {
+	if len(cAddress.ServiceFlags) != length_UINT64 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(cAddress.ServiceFlags)
+}
+
 func (cService CService) String() string {
-	return fmt.Sprintf("%s:%d", cService.IPAddress, cService.Port)
+	return fmt.Sprintf("%s:%d", cService.Host(), cService.Port)
+}
+
+// MarshalJSON renders the header byte fields as hex. NKey is the secret
+// addrman uses to randomize bucket selection, so exposing it allows a node's
+// bucket placement to be reproduced.
+func (peersDB PeersDB) MarshalJSON() ([]byte, error) {
+	type Alias PeersDB
+	return json.Marshal(&struct {
+		MessageBytes string `json:"message_bytes"`
+		NKey         string `json:"nkey"`
+		*Alias
+	}{
+		MessageBytes: hexstring(peersDB.MessageBytes),
+		NKey:         hexstring(peersDB.NKey),
+		Alias:        (*Alias)(&peersDB),
+	})
 }
 
 func (cAddress *CAddress) MarshalJSON() ([]byte, error) {
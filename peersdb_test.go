@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// mainnetFixture is a faithfully hand built peers.dat as Core 0.21 to 27
+// write it: format 3 and lowest compatible format 3, every entry in addrv2,
+// the bucket layout and a zero asmap checksum
+func mainnetFixture() fixtureFile {
+	return fixtureFile{
+		version:    FormatBIP155,
+		compatible: FormatBIP155,
+		new: [][]byte{
+			addrV2Entry(BIP155IPv4, []byte{1, 2, 3, 4}, 8333, 1700000000, NodeNetwork|NodeWitness, "5.6.7.8"),
+			addrV2Entry(BIP155IPv6, net.ParseIP("2001:4860::1"), 8333, 1699990000, NodeNetwork|NodeWitness|NodeNetworkLimited, "5.6.7.8"),
+			addrV2Entry(BIP155TorV3, bytes.Repeat([]byte{0x42}, 32), 8333, 1699980000, NodeNetwork|NodeWitness, "5.6.7.8"),
+			addrV2Entry(BIP155I2P, bytes.Repeat([]byte{0x17}, 32), 0, 1699970000, NodeNetwork|NodeWitness, "5.6.7.8"),
+			addrV2Entry(BIP155CJDNS, net.ParseIP("fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa"), 8333, 1699960000, NodeNetwork|NodeWitness, "5.6.7.8"),
+		},
+		tried: [][]byte{
+			addrV2Entry(BIP155IPv4, []byte{9, 9, 9, 9}, 8333, 1699999000, NodeNetwork|NodeWitness|NodeCompactFilters, "5.6.7.8"),
+			addrV2Entry(BIP155IPv6, net.ParseIP("2a01:4f8::2"), 8333, 1699998000, NodeNetwork|NodeWitness, "5.6.7.8"),
+		},
+	}
+}
+
+func TestParseMainnetFixture(t *testing.T) {
+	peersDB := parseFixture(t, mainnetFixture())
+
+	if peersDB.NetworkName() != "mainnet" || peersDB.Version != FormatBIP155 || peersDB.LowestCompatible() != FormatBIP155 {
+		t.Errorf("got %s format %d compatible with %d, want mainnet format 3", peersDB.NetworkName(), peersDB.Version, peersDB.LowestCompatible())
+	}
+	if peersDB.NNew != 5 || peersDB.NTried != 2 || peersDB.NewBuckets != newBucketCount {
+		t.Errorf("got %d new, %d tried and %d buckets", peersDB.NNew, peersDB.NTried, peersDB.NewBuckets)
+	}
+	if !peersDB.VerifyChecksum() {
+		t.Error("checksum doesn't verify")
+	}
+
+	for i, want := range []struct {
+		network Network
+		host    string
+		port    uint16
+	}{
+		{NetworkIPv4, "1.2.3.4", 8333},
+		{NetworkIPv6, "2001:4860::1", 8333},
+		{NetworkTor, ".onion", 8333},
+		{NetworkI2P, ".b32.i2p", 0},
+		{NetworkCJDNS, "fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa", 8333},
+	} {
+		service := peersDB.NewAddrInfo[i].Address.PeerAddress
+		if service.Network() != want.network || !strings.HasSuffix(service.Host(), want.host) || service.Port != want.port {
+			t.Errorf("new entry %d is %s %s, want %s %s:%d", i, service.Network(), service, want.network, want.host, want.port)
+		}
+		if peersDB.NewAddrInfo[i].BucketIndex != i {
+			t.Errorf("new entry %d placed in bucket %d", i, peersDB.NewAddrInfo[i].BucketIndex)
+		}
+	}
+	if host := peersDB.NewAddrInfo[2].Address.PeerAddress.Host(); len(host) != 56+len(".onion") {
+		t.Errorf("Tor v3 name %s isn't 56 characters", host)
+	}
+
+	for _, info := range peersDB.TriedAddrInfo {
+		if info.Address.Services()&NodeNetwork == 0 || info.Address.Time < 1600000000 || info.SourceService().Host() != "5.6.7.8" {
+			t.Errorf("implausible tried entry %s", info.Address.PeerAddress)
+		}
+	}
+	if got := peersDB.TriedAddrInfo[0].Address.Services(); got != NodeNetwork|NodeWitness|NodeCompactFilters {
+		t.Errorf("tried services %#x", got)
+	}
+}
+
+func TestParseCorruptFiles(t *testing.T) {
+	valid := legacyFixture(20, 1700000000).bytes()
+	for _, test := range []struct {
+		name    string
+		corrupt func([]byte) []byte
+		want    error
+	}{
+		{"truncated header", func(b []byte) []byte { return b[:peersHeaderSize-1] }, ErrTruncated},
+		{"truncated table", func(b []byte) []byte { return b[:peersHeaderSize+5*cAddrInfoSize+7] }, ErrTruncated},
+		{"flipped address byte", func(b []byte) []byte {
+			b[peersHeaderSize+cAddrInfoSize+30] ^= 1
+			return b
+		}, ErrChecksumMismatch},
+		{"flipped checksum byte", func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}, ErrChecksumMismatch},
+		{"huge new count", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[38:], 1<<31)
+			return b
+		}, ErrImplausibleCount},
+		{"huge tried count", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[42:], maxTried+1)
+			return b
+		}, ErrImplausibleCount},
+		{"bad magic", func(b []byte) []byte {
+			copy(b, []byte{0xde, 0xad, 0xbe, 0xef})
+			return b
+		}, ErrBadMagic},
+		{"incompatible format", func(b []byte) []byte {
+			b[5] = incompatibilityBase + latestFormat + 1
+			return b
+		}, ErrUnsupportedVersion},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.corrupt(append([]byte{}, valid...))
+			_, err := NewPeersDB(writeFixture(t, "peers.dat", data))
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestTruncatedFileKeepsParsedEntries(t *testing.T) {
+	data := legacyFixture(20, 1700000000).bytes()
+	peersDB, err := NewPeersDB(writeFixture(t, "peers.dat", data[:peersHeaderSize+5*cAddrInfoSize+7]))
+	if !errors.Is(err, ErrTruncated) || len(peersDB.NewAddrInfo) != 5 {
+		t.Errorf("got %d entries and %v, want the 5 before the cut and ErrTruncated", len(peersDB.NewAddrInfo), err)
+	}
+}
+
+func TestChecksumMismatchKeepsParsedEntries(t *testing.T) {
+	data := legacyFixture(20, 1700000000).bytes()
+	data[len(data)-1] ^= 1
+	peersDB, err := NewPeersDB(writeFixture(t, "peers.dat", data))
+	if !errors.Is(err, ErrChecksumMismatch) || peersDB.VerifyChecksum() || len(peersDB.NewAddrInfo) != 20 {
+		t.Errorf("got %d entries, checksum valid %t and %v", len(peersDB.NewAddrInfo), peersDB.VerifyChecksum(), err)
+	}
+
+	lenient, _, err := NewPeersDBLenient(writeFixture(t, "peers.dat", data))
+	if err != nil || lenient.VerifyChecksum() || len(lenient.NewAddrInfo) != 20 {
+		t.Errorf("lenient parse got %d entries, checksum valid %t and %v", len(lenient.NewAddrInfo), lenient.VerifyChecksum(), err)
+	}
+}
+
+func TestLenientSkippedOffsets(t *testing.T) {
+	data := legacyFixture(20, 1700000000).bytes()
+	bad := uint64(peersHeaderSize + 3*cAddrInfoSize)
+	data[bad] ^= 1
+	cut := uint64(peersHeaderSize + 22*cAddrInfoSize)
+
+	peersDB, skipped, err := NewPeersDBLenient(writeFixture(t, "peers.dat", data[:cut+7]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peersDB.NewAddrInfo) != 19 || len(peersDB.TriedAddrInfo) != 2 {
+		t.Errorf("got %d new and %d tried entries, want 19 and 2", len(peersDB.NewAddrInfo), len(peersDB.TriedAddrInfo))
+	}
+	if len(skipped) != 2 || skipped[0] != bad || skipped[1] != cut {
+		t.Errorf("got skipped offsets %v, want [%d %d]", skipped, bad, cut)
+	}
+}
+
+func TestNetworkMagic(t *testing.T) {
+	for _, chain := range knownChains {
+		file := legacyFixture(4, 1700000000)
+		file.magic = chain.Magic
+		peersDB := parseFixture(t, file)
+		if peersDB.NetworkName() != chain.Name || peersDB.Magic() != [4]byte(chain.Magic) {
+			t.Errorf("%s file detected as %s", chain.Name, peersDB.NetworkName())
+		}
+	}
+
+	file := legacyFixture(4, 1700000000)
+	file.magic = []byte{0xde, 0xad, 0xbe, 0xef}
+	if _, err := NewPeersDB(writeFixture(t, "peers.dat", file.bytes())); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("got %v for an unknown magic, want ErrBadMagic", err)
+	}
+	if got := (PeersDB{MessageBytes: file.magic}).NetworkName(); got != "unknown" {
+		t.Errorf("unknown magic named %s", got)
+	}
+}
+
+func TestImplausibleCounts(t *testing.T) {
+	// a header claiming billions of entries is rejected before anything is
+	// allocated for them
+	data := legacyFixture(2, 1700000000).bytes()
+	binary.LittleEndian.PutUint32(data[38:], 4000000000)
+	if _, err := NewPeersDB(writeFixture(t, "peers.dat", data)); !errors.Is(err, ErrImplausibleCount) {
+		t.Errorf("got %v, want ErrImplausibleCount", err)
+	}
+
+	// a count addrman could hold but the file can't is a truncation
+	data = legacyFixture(2, 1700000000).bytes()
+	binary.LittleEndian.PutUint32(data[38:], maxNew)
+	peersDB, err := NewPeersDB(writeFixture(t, "peers.dat", data))
+	if !errors.Is(err, ErrTruncated) || cap(peersDB.NewAddrInfo) >= maxNew {
+		t.Errorf("got %v with room for %d entries, want ErrTruncated without preallocating", err, cap(peersDB.NewAddrInfo))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+// AgeHistogram estimates percentiles of a stream of ages in bounded memory.
+// Ages are counted into fixed width buckets up to a maximum; a percentile is
+// located by rank and linearly interpolated within its bucket, so for ages
+// below the maximum the estimate is within one bucket width of the exact
+// value. Ages past the maximum share a single overflow bucket, and
+// percentiles falling into it are bounded by the largest age seen.
+type AgeHistogram struct {
+	Width   uint32
+	buckets []int
+	over    int
+	total   int
+	largest uint32
+}
+
+// DefaultHistogramWidth and DefaultHistogramSpan make a histogram of one
+// hour buckets covering a year, about 70KB whatever the table size
+const DefaultHistogramWidth = 60 * 60
+const DefaultHistogramSpan = 365 * ONE_DAY
+
+// NewAgeHistogram creates a histogram of buckets width seconds wide covering
+// ages from 0 to span seconds
+func NewAgeHistogram(width, span uint32) *AgeHistogram {
+	if width == 0 {
+		width = 1
+	}
+	return &AgeHistogram{
+		Width:   width,
+		buckets: make([]int, (span+width-1)/width),
+	}
+}
+
+// Add counts one age, in seconds
+func (histogram *AgeHistogram) Add(age uint32) {
+	histogram.total++
+	if age > histogram.largest {
+		histogram.largest = age
+	}
+	if i := int(age / histogram.Width); i < len(histogram.buckets) {
+		histogram.buckets[i]++
+	} else {
+		histogram.over++
+	}
+}
+
+// Count returns the number of ages added
+func (histogram *AgeHistogram) Count() int {
+	return histogram.total
+}
+
+// Percentile estimates the age in seconds below which the fraction p of the
+// ages lie, for p between 0 and 1. An empty histogram yields 0.
+func (histogram *AgeHistogram) Percentile(p float64) float64 {
+	if histogram.total == 0 {
+		return 0
+	}
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+
+	rank := p * float64(histogram.total)
+	seen := 0
+	for i, count := range histogram.buckets {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+		low := float64(uint32(i) * histogram.Width)
+		estimate := low + float64(histogram.Width)*(rank-float64(seen))/float64(count)
+		if estimate > float64(histogram.largest) {
+			estimate = float64(histogram.largest)
+		}
+		return estimate
+	}
+
+	// in the overflow bucket, interpolate between its start and the largest
+	// age seen
+	low := float64(uint32(len(histogram.buckets)) * histogram.Width)
+	return low + (float64(histogram.largest)-low)*(rank-float64(seen))/float64(histogram.over)
+}
+
+// Median estimates the median age in seconds
+func (histogram *AgeHistogram) Median() float64 {
+	return histogram.Percentile(0.5)
+}
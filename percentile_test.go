@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// exactPercentile is the age of rank p among sorted ages
+func exactPercentile(sorted []uint32, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i])
+}
+
+func TestAgeHistogramWithinOneBucket(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	histogram := NewAgeHistogram(DefaultHistogramWidth, DefaultHistogramSpan)
+	var ages []uint32
+	for i := 0; i < 100000; i++ {
+		// skewed towards young addresses, as in a real table
+		age := uint32(random.ExpFloat64() * 20 * ONE_DAY)
+		if age >= DefaultHistogramSpan {
+			age = DefaultHistogramSpan - 1
+		}
+		ages = append(ages, age)
+		histogram.Add(age)
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+
+	if histogram.Count() != len(ages) {
+		t.Errorf("counted %d ages, want %d", histogram.Count(), len(ages))
+	}
+	for _, p := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 1} {
+		exact := exactPercentile(ages, p)
+		if got := histogram.Percentile(p); math.Abs(got-exact) > DefaultHistogramWidth {
+			t.Errorf("p%v: estimated %v, exact %v, more than a bucket apart", p*100, got, exact)
+		}
+	}
+	if got, exact := histogram.Median(), exactPercentile(ages, 0.5); math.Abs(got-exact) > DefaultHistogramWidth {
+		t.Errorf("median estimated %v, exact %v", got, exact)
+	}
+}
+
+func TestAgeHistogramEdgeCases(t *testing.T) {
+	if got := NewAgeHistogram(10, 100).Percentile(0.5); got != 0 {
+		t.Errorf("empty histogram: got %v, want 0", got)
+	}
+
+	single := NewAgeHistogram(10, 100)
+	single.Add(42)
+	for _, p := range []float64{0, 0.5, 1} {
+		if got := single.Percentile(p); got < 40 || got > 42 {
+			t.Errorf("single age 42, p%v: got %v", p*100, got)
+		}
+	}
+
+	// p is clamped to [0, 1]
+	histogram := NewAgeHistogram(10, 100)
+	for age := uint32(0); age < 100; age++ {
+		histogram.Add(age)
+	}
+	if below, zero := histogram.Percentile(-1), histogram.Percentile(0); below != zero {
+		t.Errorf("p below 0 gave %v, p0 %v", below, zero)
+	}
+	if above, largest := histogram.Percentile(2), histogram.Percentile(1); above != largest || largest > 99 {
+		t.Errorf("p above 1 gave %v, p100 %v, want at most the largest age 99", above, largest)
+	}
+
+	// ages past the span are bounded by the largest seen
+	overflow := NewAgeHistogram(10, 100)
+	for _, age := range []uint32{5, 500, 1000, 2000} {
+		overflow.Add(age)
+	}
+	if got := overflow.Percentile(1); got != 2000 {
+		t.Errorf("p100 with overflow: got %v, want the largest age 2000", got)
+	}
+	if got := overflow.Percentile(0.75); got < 100 || got > 2000 {
+		t.Errorf("p75 in the overflow bucket: got %v, want between the span and the largest age", got)
+	}
+
+	// a zero width is taken as 1
+	if histogram := NewAgeHistogram(0, 10); histogram.Width != 1 {
+		t.Errorf("zero width became %d, want 1", histogram.Width)
+	}
+}
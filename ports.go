@@ -0,0 +1,24 @@
+package main
+
+// ModalPort returns the most common port among the entries and how many
+// entries use it. Ties go to the lower port so the result is deterministic.
+func ModalPort(infos []CAddrInfo) (port uint16, count int) {
+	counts := make(map[uint16]int)
+	for _, info := range infos {
+		counts[info.Address.PeerAddress.Port]++
+	}
+	for p, c := range counts {
+		if c > count || (c == count && p < port) {
+			port, count = p, c
+		}
+	}
+	return
+}
+
+// DefaultPortMismatch reports whether the dominant port of a table differs
+// from the chain's default port, which usually means a peers.dat from one
+// network is being analysed as another. Empty tables never mismatch.
+func DefaultPortMismatch(infos []CAddrInfo, chain Chain) (port uint16, mismatch bool) {
+	port, count := ModalPort(infos)
+	return port, count > 0 && port != chain.DefaultPort
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// entryRecord keeps what WeightedReachability and RollupByDay need to know
+// of each entry
+type entryRecord struct {
+	key       string
+	age       uint32 // seconds before the approx age
+	reachable bool
+}
+
+// recordEntries notes the age and reachability of each entry of table
+func (result *Result) recordEntries(table []CAddrInfo, approxAge uint32, reachableIPs []string) {
+	reachable := make(map[string]bool, len(reachableIPs))
+	for _, ip := range reachableIPs {
+		reachable[ip] = true
+	}
+
+	result.entries = make([]entryRecord, len(table))
+	for i, info := range table {
+		var age uint32
+		if approxAge > info.Address.Time {
+			age = approxAge - info.Address.Time
+		}
+		result.entries[i] = entryRecord{
+			key:       info.Address.PeerAddress.Key(),
+			age:       age,
+			reachable: reachable[reachabilityKey(info.Address.PeerAddress)],
+		}
+	}
+}
+
+// WeightedReachability scores reachability with each entry weighted by an
+// exponential decay of its age, 2^(-age/halfLife), so an entry halfLife old
+// counts half as much as a fresh one. The score is the weighted share of
+// reachable entries, between 0 and 1, and 0 for an empty table or a
+// non-positive halfLife.
+func (result *Result) WeightedReachability(halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return 0
+	}
+
+	var total, reachable float64
+	for _, entry := range result.entries {
+		weight := math.Exp2(-float64(entry.age) / halfLife.Seconds())
+		total += weight
+		if entry.reachable {
+			reachable += weight
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return reachable / total
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// SinceLastSuccess returns the seconds between the entry's last successful
+// connection and reference. ok is false if the node never connected to it.
+func (cAddrInfo CAddrInfo) SinceLastSuccess(reference uint32) (seconds uint32, ok bool) {
+	if cAddrInfo.LastSuccess == 0 {
+		return 0, false
+	}
+	if cAddrInfo.LastSuccess >= uint64(reference) {
+		return 0, true
+	}
+	return reference - uint32(cAddrInfo.LastSuccess), true
+}
+
+// SuccessLag returns how long before its advertised time the entry last
+// connected successfully. A small lag means the node was still reaching the
+// peer around the time it was last heard of, a rough proxy of its uptime.
+func (cAddrInfo CAddrInfo) SuccessLag() (seconds uint32, ok bool) {
+	return cAddrInfo.SinceLastSuccess(cAddrInfo.Address.Time)
+}
+
+// AddToLastSuccessBucket buckets the entry by days since its last success,
+// counting entries that never succeeded separately
+func AddToLastSuccessBucket(result *Result, cAddrInfo CAddrInfo, approxAge uint32) {
+	if cAddrInfo.LastSuccess == 0 {
+		result.NeverSucceeded++
+		return
+	}
+
+	seconds, _ := cAddrInfo.SinceLastSuccess(approxAge)
+	AddToAgeBucket(&result.LastSuccessAge, approxAge-seconds, approxAge)
+}
+
+// PromotionAnalysis approximates the order in which a node promoted
+// addresses to its tried table
+type PromotionAnalysis struct {
+	// Order holds the tried entries which ever succeeded, by LastSuccess
+	Order []CAddrInfo
+	// Burstiness is the Goh-Barabási coefficient (σ-μ)/(σ+μ) of the gaps
+	// between successive successes: -1 for perfectly regular promotions,
+	// around 0 for random ones and approaching 1 when they come in bursts.
+	// It is 0 when there are fewer than three successes.
+	Burstiness float64
+}
+
+// AnalyzePromotions sorts the tried entries by their last success and
+// measures how clustered in time those successes are
+func AnalyzePromotions(tried []CAddrInfo) PromotionAnalysis {
+	order := Filter(tried, func(info CAddrInfo) bool {
+		return info.LastSuccess != 0
+	})
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].LastSuccess < order[j].LastSuccess
+	})
+
+	analysis := PromotionAnalysis{Order: order}
+	if len(order) < 3 {
+		return analysis
+	}
+
+	gaps := make([]float64, len(order)-1)
+	var mean float64
+	for i := 1; i < len(order); i++ {
+		gaps[i-1] = float64(order[i].LastSuccess - order[i-1].LastSuccess)
+		mean += gaps[i-1]
+	}
+	mean /= float64(len(gaps))
+
+	var variance float64
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(gaps)))
+
+	if stddev+mean > 0 {
+		analysis.Burstiness = (stddev - mean) / (stddev + mean)
+	}
+	return analysis
+}
+
+// Thresholds of Bitcoin Core's CAddrInfo::IsTerrible, see addrman.h
+const (
+	AddrmanHorizon     = 30 * ONE_DAY // entries not heard of for this long are dropped
+	AddrmanRetries     = 3            // failed attempts tolerated before a first success
+	AddrmanMaxFailures = 10           // failed attempts tolerated since a success...
+	AddrmanMinFail     = 7 * ONE_DAY  // ...once the success is this old
+	addrmanMaxFuture   = 10 * 60      // timestamps this far ahead are bogus
+)
+
+// IsTerrible reports whether Core would consider the entry not worth
+// keeping, as of now:
+//   - its timestamp is more than 10 minutes in the future
+//   - its timestamp is more than 30 days old
+//   - it never succeeded and has had at least 3 attempts
+//   - its last success is more than 7 days old and it has had at least 10
+//     attempts since
+//
+// Core also spares entries tried in the last minute, but the time of the
+// last try isn't saved to peers.dat so that exemption can't be applied.
+func IsTerrible(info CAddrInfo, now uint32) bool {
+	if info.Address.Time > now && info.Address.Time-now > addrmanMaxFuture {
+		return true
+	}
+	if info.Address.Time == 0 || (now > info.Address.Time && now-info.Address.Time > AddrmanHorizon) {
+		return true
+	}
+	if info.LastSuccess == 0 && info.Attempts >= AddrmanRetries {
+		return true
+	}
+	if seconds, ok := info.SinceLastSuccess(now); ok && seconds > AddrmanMinFail && info.Attempts >= AddrmanMaxFailures {
+		return true
+	}
+	return false
+}
+
+// CountTerrible counts the entries IsTerrible as of now
+func CountTerrible(infos []CAddrInfo, now uint32) int {
+	count := 0
+	for _, info := range infos {
+		if IsTerrible(info, now) {
+			count++
+		}
+	}
+	return count
+}
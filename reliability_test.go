@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestIsTerribleBoundaries(t *testing.T) {
+	const now = 1700000000
+	entry := func(time uint32, lastSuccess uint64, attempts uint32) CAddrInfo {
+		info := addrInfo("1.2.3.4", 8333, time, NodeNetwork)
+		info.LastSuccess, info.Attempts = lastSuccess, attempts
+		return info
+	}
+
+	for _, test := range []struct {
+		name string
+		info CAddrInfo
+		want bool
+	}{
+		{"fresh", entry(now, now, 0), false},
+		{"10 minutes ahead", entry(now+10*60, now, 0), false},
+		{"past 10 minutes ahead", entry(now+10*60+1, now, 0), true},
+		{"30 days old", entry(now-AddrmanHorizon, now, 0), false},
+		{"past 30 days old", entry(now-AddrmanHorizon-1, now, 0), true},
+		{"no timestamp", entry(0, now, 0), true},
+		{"never succeeded, 2 attempts", entry(now, 0, AddrmanRetries-1), false},
+		{"never succeeded, 3 attempts", entry(now, 0, AddrmanRetries), true},
+		{"success 7 days ago, 10 attempts", entry(now, now-AddrmanMinFail, AddrmanMaxFailures), false},
+		{"success past 7 days ago, 10 attempts", entry(now, now-AddrmanMinFail-1, AddrmanMaxFailures), true},
+		{"success past 7 days ago, 9 attempts", entry(now, now-AddrmanMinFail-1, AddrmanMaxFailures-1), false},
+		{"recent success, many attempts", entry(now, now-ONE_DAY, 100), false},
+	} {
+		if got := IsTerrible(test.info, now); got != test.want {
+			t.Errorf("%s: IsTerrible = %t, want %t", test.name, got, test.want)
+		}
+	}
+
+	infos := []CAddrInfo{entry(now, now, 0), entry(0, now, 0), entry(now, 0, 5)}
+	if got := CountTerrible(infos, now); got != 2 {
+		t.Errorf("CountTerrible = %d, want 2", got)
+	}
+}
+
+func TestAnalyzePromotions(t *testing.T) {
+	tried := func(lastSuccesses ...uint64) []CAddrInfo {
+		var infos []CAddrInfo
+		for _, lastSuccess := range lastSuccesses {
+			info := addrInfo("1.2.3.4", 8333, 1700000000, NodeNetwork)
+			info.LastSuccess = lastSuccess
+			infos = append(infos, info)
+		}
+		return infos
+	}
+
+	// never succeeded entries are left out and the rest ordered
+	analysis := AnalyzePromotions(tried(300, 0, 100, 200, 0))
+	if len(analysis.Order) != 3 || analysis.Order[0].LastSuccess != 100 || analysis.Order[2].LastSuccess != 300 {
+		t.Errorf("got order %v", analysis.Order)
+	}
+
+	for _, test := range []struct {
+		name          string
+		lastSuccesses []uint64
+		check         func(float64) bool
+	}{
+		{"evenly spaced", []uint64{1000, 2000, 3000, 4000, 5000}, func(b float64) bool { return b == -1 }},
+		{"no successes", nil, func(b float64) bool { return b == 0 }},
+		{"one success", []uint64{1000}, func(b float64) bool { return b == 0 }},
+		{"two successes", []uint64{1000, 9000}, func(b float64) bool { return b == 0 }},
+		{"simultaneous", []uint64{1000, 1000, 1000}, func(b float64) bool { return b == 0 }},
+		// bursts of close promotions far apart
+		{"bursty", []uint64{1000, 1001, 1002, 1003, 500000, 500001, 500002, 500003, 1000000, 1000001}, func(b float64) bool { return b > 0.3 }},
+	} {
+		if got := AnalyzePromotions(tried(test.lastSuccesses...)).Burstiness; !test.check(got) {
+			t.Errorf("%s: burstiness %v", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DailyRollup summarizes the snapshots of one table taken on the same day
+type DailyRollup struct {
+	Date            string // YYYY-MM-DD, UTC
+	Snapshots       int
+	Crawled         int // snapshots MeanPercentage averages over
+	MeanPercentage  float64
+	MeanAgeDays     float64
+	MedianAgeDays   float64
+	UniqueAddresses int
+}
+
+// RollupByDay groups results by the UTC date of their approx age and
+// summarizes each day: the mean reachable percentage across its snapshots,
+// leaving out those without crawl data or with an empty table, whose 0 isn't
+// a measurement, the mean and median age of every entry seen that day and the number of
+// distinct addresses seen. Days are returned in order. The median is
+// estimated with an AgeHistogram and is accurate to within an hour for
+// entries up to a year old.
+func RollupByDay(results []*Result) []DailyRollup {
+	byDay := make(map[string][]*Result)
+	for _, result := range results {
+		date := time.Unix(int64(result.ApproxAge), 0).UTC().Format("2006-01-02")
+		byDay[date] = append(byDay[date], result)
+	}
+
+	rollups := make([]DailyRollup, 0, len(byDay))
+	for date, dayResults := range byDay {
+		rollup := DailyRollup{
+			Date:      date,
+			Snapshots: len(dayResults),
+		}
+
+		unique := make(map[string]bool)
+		ages := NewAgeHistogram(DefaultHistogramWidth, DefaultHistogramSpan)
+		var sum float64
+		for _, result := range dayResults {
+			if !result.NoCrawlData && result.TotalIPs > 0 {
+				rollup.MeanPercentage += result.Percentage
+				rollup.Crawled++
+			}
+			for _, entry := range result.entries {
+				unique[entry.key] = true
+				ages.Add(entry.age)
+				sum += float64(entry.age)
+			}
+		}
+		if rollup.Crawled > 0 {
+			rollup.MeanPercentage /= float64(rollup.Crawled)
+		}
+		rollup.UniqueAddresses = len(unique)
+
+		if ages.Count() > 0 {
+			rollup.MeanAgeDays = sum / float64(ages.Count()) / ONE_DAY
+			rollup.MedianAgeDays = ages.Median() / ONE_DAY
+		}
+		rollups = append(rollups, rollup)
+	}
+
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Date < rollups[j].Date
+	})
+	return rollups
+}
+
+// WriteRollups writes one CSV row per day, with an NA mean percentage for
+// days none of whose snapshots it could be computed for
+func WriteRollups(w io.Writer, rollups []DailyRollup) error {
+	rows := [][]string{{"Date", "Snapshots", "Mean_PercentReachable", "Mean_Age_Days", "Median_Age_Days", "Unique_IPs"}}
+	for _, rollup := range rollups {
+		percent := formatPercent(rollup.MeanPercentage)
+		if rollup.Crawled == 0 {
+			percent = "NA"
+		}
+		rows = append(rows, []string{
+			rollup.Date,
+			strconv.Itoa(rollup.Snapshots),
+			percent,
+			strconv.FormatFloat(rollup.MeanAgeDays, 'f', 2, 64),
+			strconv.FormatFloat(rollup.MedianAgeDays, 'f', 2, 64),
+			strconv.Itoa(rollup.UniqueAddresses),
+		})
+	}
+	return writeDelimited(w, ",", rows...)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestRollupByDaySkipsMissingCrawls(t *testing.T) {
+	const day = 1700006400 // 2023-11-15 00:00 UTC
+	results := []*Result{
+		{ApproxAge: day, TotalIPs: 2, NumberOfReachableIPs: 1, Percentage: 0.5},
+		{ApproxAge: day + 3600, TotalIPs: 2, NoCrawlData: true},
+		{ApproxAge: day + 7200},
+		{ApproxAge: day + ONE_DAY, TotalIPs: 2, NoCrawlData: true},
+	}
+
+	rollups := RollupByDay(results)
+	if len(rollups) != 2 {
+		t.Fatalf("got %d days, want 2", len(rollups))
+	}
+	if rollups[0].Snapshots != 3 || rollups[0].Crawled != 1 || rollups[0].MeanPercentage != 0.5 {
+		t.Errorf("got a mean of %v over %d of %d snapshots, want 0.5 over 1 of 3", rollups[0].MeanPercentage, rollups[0].Crawled, rollups[0].Snapshots)
+	}
+
+	var out bytes.Buffer
+	if err := WriteRollups(&out, rollups); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[1][2] == "NA" || rows[2][2] != "NA" {
+		t.Errorf("got mean percentages %q and %q, want a value and NA", rows[1][2], rows[2][2])
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// the trailing double SHA256 over the network magic and the contents
+const checksumSize = sha256.Size
+
+func doubleSHA256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
+
+// checksumMatches reports whether the file ends with the checksum of what
+// precedes it
+func checksumMatches(dbbytes []byte) bool {
+	if len(dbbytes) < peersHeaderSize+checksumSize {
+		return false
+	}
+	return trailingChecksumMatches(dbbytes)
+}
+
+// trailingChecksumMatches is checksumMatches for files of any layout, such
+// as anchors.dat
+func trailingChecksumMatches(dbbytes []byte) bool {
+	if len(dbbytes) < checksumSize {
+		return false
+	}
+	end := len(dbbytes) - checksumSize
+	checksum := doubleSHA256(dbbytes[:end])
+	return bytes.Equal(checksum[:], dbbytes[end:])
+}
+
+// VerifyChecksum reports whether the file parsed ended with a valid
+// checksum. NewPeersDB already fails on a mismatch; this is for callers of
+// NewPeersDBLenient. A database not parsed from a file, such as a merged or
+// gob-decoded one, reports false.
+func (peersDB PeersDB) VerifyChecksum() bool {
+	return peersDB.checksumValid
+}
+
+// Serialize writes the database in the peers.dat format Core loads,
+// followed by a freshly computed checksum. An unmodified database parsed
+// from a file is written back byte for byte. Once entries are added, removed
+// or changed the saved bucket layout no longer indexes the tables, so the new
+// entries are referenced in order from as few pseudo buckets of bucketSize
+// as hold them instead, which makes Core place the new table afresh by
+// source when loading it, a layout of other than newBucketCount buckets not
+// being kept. Only a full new table takes newBucketCount of them.
+// The same happens for databases that didn't come from a complete file, such
+// as salvaged or gob-decoded ones.
+func (peersDB PeersDB) Serialize(w io.Writer) error {
+	if len(peersDB.MessageBytes) != 4 || len(peersDB.NKey) != 32 {
+		return fmt.Errorf("Can't serialize a database without its magic and key")
+	}
+
+	tables, err := peersDB.encodeTables()
+	if err != nil {
+		return err
+	}
+
+	unchanged := peersDB.layout != nil && sha256.Sum256(tables) == peersDB.tablesDigest
+	newBuckets := peersDB.NewBuckets
+	if !unchanged {
+		newBuckets = uint32((len(peersDB.NewAddrInfo) + bucketSize - 1) / bucketSize)
+	}
+
+	var data bytes.Buffer
+	data.Write(peersDB.MessageBytes)
+	data.WriteByte(peersDB.Version)
+	data.WriteByte(peersDB.KeySize)
+	data.Write(peersDB.NKey)
+	binary.Write(&data, binary.LittleEndian, uint32(len(peersDB.NewAddrInfo)))
+	binary.Write(&data, binary.LittleEndian, uint32(len(peersDB.TriedAddrInfo)))
+	if peersDB.Version >= FormatDeterministic {
+		newBuckets ^= 1 << 30
+	}
+	binary.Write(&data, binary.LittleEndian, newBuckets)
+	data.Write(tables)
+
+	if unchanged {
+		data.Write(peersDB.layout)
+	} else {
+		for start := 0; start < len(peersDB.NewAddrInfo); start += bucketSize {
+			end := min(start+bucketSize, len(peersDB.NewAddrInfo))
+			binary.Write(&data, binary.LittleEndian, uint32(end-start))
+			for i := start; i < end; i++ {
+				binary.Write(&data, binary.LittleEndian, uint32(i))
+			}
+		}
+		if peersDB.Version >= FormatASMap {
+			// no asmap
+			data.Write(make([]byte, 32))
+		}
+	}
+
+	checksum := doubleSHA256(data.Bytes())
+	data.Write(checksum[:])
+
+	_, err = w.Write(data.Bytes())
+	return err
+}
+
+// encodeTables encodes the new table followed by the tried table
+func (peersDB PeersDB) encodeTables() ([]byte, error) {
+	var tables bytes.Buffer
+	for _, table := range [][]CAddrInfo{peersDB.NewAddrInfo, peersDB.TriedAddrInfo} {
+		for _, info := range table {
+			if err := writeCAddrInfo(&tables, info); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tables.Bytes(), nil
+}
+
+// writeCAddrInfo writes an entry in the format its serialization version
+// flags, the inverse of readCAddrInfo
+func writeCAddrInfo(w *bytes.Buffer, info CAddrInfo) error {
+	address := info.Address
+	if len(address.SerializationVersion) != 4 {
+		return fmt.Errorf("Can't serialize %s without its serialization version", address.PeerAddress)
+	}
+	w.Write(address.SerializationVersion)
+	binary.Write(w, binary.LittleEndian, address.Time)
+
+	if binary.LittleEndian.Uint32(address.SerializationVersion)&addrV2Format == 0 {
+		w.Write(reverseBytes(append([]byte{}, address.ServiceFlags...)))
+		w.Write(address.PeerAddress.IPAddress.To16())
+		binary.Write(w, binary.BigEndian, address.PeerAddress.Port)
+		w.Write(info.Source.To16())
+	} else {
+		writeCompactSize(w, address.Services())
+		if err := writeAddrV2(w, address.PeerAddress.NetworkID, address.PeerAddress.IPAddress); err != nil {
+			return err
+		}
+		binary.Write(w, binary.BigEndian, address.PeerAddress.Port)
+		if err := writeAddrV2(w, info.SourceNetworkID, info.Source); err != nil {
+			return err
+		}
+	}
+
+	binary.Write(w, binary.LittleEndian, info.LastSuccess)
+	binary.Write(w, binary.LittleEndian, info.Attempts)
+	return nil
+}
+
+// writeAddrV2 writes a network id and address, the inverse of readAddrV2
+func writeAddrV2(w *bytes.Buffer, networkID BIP155Network, addr []byte) error {
+	switch networkID {
+	case BIP155IPv4:
+		addr = []byte(net.IP(addr).To4())
+	case BIP155TorV2:
+		if len(addr) == 16 {
+			addr = addr[len(onionCatPrefix):]
+		}
+	case BIP155Legacy:
+		return fmt.Errorf("Can't serialize an address without its network in addrv2")
+	}
+	w.WriteByte(byte(networkID))
+	writeCompactSize(w, uint64(len(addr)))
+	w.Write(addr)
+	return nil
+}
+
+// writeCompactSize writes Bitcoin's variable length integer in its
+// canonical, shortest form
+func writeCompactSize(w *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		w.WriteByte(0xfd)
+		binary.Write(w, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		w.WriteByte(0xfe)
+		binary.Write(w, binary.LittleEndian, uint32(n))
+	default:
+		w.WriteByte(0xff)
+		binary.Write(w, binary.LittleEndian, n)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	for name, file := range map[string]fixtureFile{
+		"legacy": legacyFixture(100, 1700000000),
+		"addrv2": {
+			version:    FormatBIP155,
+			compatible: FormatBIP155,
+			new: [][]byte{
+				addrV2Entry(BIP155TorV3, bytes.Repeat([]byte{7}, 32), 8333, 1700000000, 1033, "1.4.9.9"),
+				addrV2Entry(BIP155IPv4, []byte{1, 2, 0, 2}, 8333, 1699990000, 1033, "1.4.9.9"),
+			},
+			tried: [][]byte{addrV2Entry(BIP155I2P, bytes.Repeat([]byte{9}, 32), 0, 1699999000, 1, "1.4.9.9")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := file.bytes()
+			peersDB, err := NewPeersDB(writeFixture(t, "peers.dat", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := peersDB.Serialize(&out); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Errorf("Serialize wrote %d bytes differing from the %d parsed", out.Len(), len(data))
+			}
+		})
+	}
+}
+
+func TestSerializeRebucketsChangedTables(t *testing.T) {
+	RetainBuckets = true
+	defer func() { RetainBuckets = false }()
+
+	peersDB := parseFixture(t, legacyFixture(200, 1700000000))
+	peersDB.NewAddrInfo = peersDB.NewAddrInfo[1:]
+
+	var out bytes.Buffer
+	if err := peersDB.Serialize(&out); err != nil {
+		t.Fatal(err)
+	}
+	path := writeFixture(t, "peers.dat", out.Bytes())
+	reparsed, err := NewPeersDB(path)
+	if err != nil {
+		t.Fatalf("Couldn't parse serialized file: %s", err)
+	}
+	if len(reparsed.NewAddrInfo) != 199 || len(reparsed.NewBucketEntries) != 199 {
+		t.Errorf("got %d new entries and %d bucket references, want 199", len(reparsed.NewAddrInfo), len(reparsed.NewBucketEntries))
+	}
+	if want := uint32(4); reparsed.NewBuckets != want {
+		t.Errorf("got %d pseudo buckets, want %d", reparsed.NewBuckets, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import "fmt"
+
+// Service bits advertised in CAddress, as defined by Bitcoin Core
+const (
+	NodeNetwork        uint64 = 1 << 0
+	NodeGetUTXO        uint64 = 1 << 1
+	NodeBloom          uint64 = 1 << 2
+	NodeWitness        uint64 = 1 << 3
+	NodeCompactFilters uint64 = 1 << 6
+	NodeNetworkLimited uint64 = 1 << 10
+	NodeP2PV2          uint64 = 1 << 11
+)
+
+// MajorServices are the flags reachability is broken down by
+var MajorServices = []uint64{NodeNetwork, NodeBloom, NodeWitness, NodeCompactFilters, NodeNetworkLimited}
+
+var serviceNames = map[uint64]string{
+	NodeNetwork:        "NODE_NETWORK",
+	NodeGetUTXO:        "NODE_GETUTXO",
+	NodeBloom:          "NODE_BLOOM",
+	NodeWitness:        "NODE_WITNESS",
+	NodeCompactFilters: "NODE_COMPACT_FILTERS",
+	NodeNetworkLimited: "NODE_NETWORK_LIMITED",
+	NodeP2PV2:          "NODE_P2P_V2",
+}
+
+// ServiceName returns Core's name for a single service bit
+func ServiceName(flag uint64) string {
+	if name, ok := serviceNames[flag]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ServiceNames lists the names of the bits set in services, lowest first.
+// Bits without a name are listed by number, e.g. "BIT_24".
+func ServiceNames(services uint64) []string {
+	var names []string
+	for bit := uint(0); bit < 64; bit++ {
+		flag := uint64(1) << bit
+		if services&flag == 0 {
+			continue
+		}
+		if name, ok := serviceNames[flag]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("BIT_%d", bit))
+		}
+	}
+	return names
+}
+
+// ServiceReachability counts the entries advertising a service and how many
+// of them were reachable
+type ServiceReachability struct {
+	Flag      uint64
+	Total     int
+	Reachable int
+}
+
+// Percentage returns the reachable fraction, 0 when no entry advertised the
+// service
+func (s ServiceReachability) Percentage() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Reachable) / float64(s.Total)
+}
+
+// ServiceBreakdown computes reachability for each of MajorServices among the
+// entries of table, given the reachable IPs found in it
+func ServiceBreakdown(table []CAddrInfo, reachableIPs []string) []ServiceReachability {
+	reachable := make(map[string]bool, len(reachableIPs))
+	for _, ip := range reachableIPs {
+		reachable[ip] = true
+	}
+
+	breakdown := make([]ServiceReachability, len(MajorServices))
+	for i, flag := range MajorServices {
+		breakdown[i].Flag = flag
+	}
+
+	for _, info := range table {
+		services := info.Address.Services()
+		isReachable := reachable[reachabilityKey(info.Address.PeerAddress)]
+		for i := range breakdown {
+			if services&breakdown[i].Flag == 0 {
+				continue
+			}
+			breakdown[i].Total++
+			if isReachable {
+				breakdown[i].Reachable++
+			}
+		}
+	}
+	return breakdown
+}
+
+// CountP2PV2 returns how many entries advertise BIP324 v2 transport
+func CountP2PV2(table []CAddrInfo) int {
+	return len(Filter(table, ByServiceFlag(NodeP2PV2)))
+}
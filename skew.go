@@ -0,0 +1,48 @@
+package main
+
+// DefaultSkewThreshold is how far ahead of the reference an entry's time may
+// be before it counts as skewed. Core treats addresses more than ten minutes
+// in the future as terrible.
+const DefaultSkewThreshold = 10 * 60
+
+// SkewedEntry is an entry timestamped after the reference time
+type SkewedEntry struct {
+	Info CAddrInfo
+	Skew uint32 // seconds ahead of the reference
+}
+
+// ClockSkewReport lists the entries timestamped too far in the future,
+// which points at a peer, or this node, having a bad clock
+type ClockSkewReport struct {
+	Reference uint32
+	Threshold uint32
+	Entries   []SkewedEntry
+	MaxSkew   uint32
+}
+
+// Count returns the number of skewed entries
+func (report ClockSkewReport) Count() int {
+	return len(report.Entries)
+}
+
+// CheckClockSkew reports the entries whose Time is more than threshold
+// seconds after reference
+func CheckClockSkew(infos []CAddrInfo, reference, threshold uint32) ClockSkewReport {
+	report := ClockSkewReport{
+		Reference: reference,
+		Threshold: threshold,
+	}
+
+	for _, info := range infos {
+		if info.Address.Time <= reference || info.Address.Time-reference <= threshold {
+			continue
+		}
+
+		skew := info.Address.Time - reference
+		report.Entries = append(report.Entries, SkewedEntry{Info: info, Skew: skew})
+		if skew > report.MaxSkew {
+			report.MaxSkew = skew
+		}
+	}
+	return report
+}
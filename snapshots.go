@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+)
+
+// SnapshotCache keeps the normalized hosts of recently read bitnode
+// snapshots. In batch mode most nodes fall on the same few snapshots, which
+// are then read once. Snapshots are keyed by file, that is by timestamp
+// within an archive, and the least recently used is evicted once more than
+// Limit are held.
+type SnapshotCache struct {
+	Limit   int
+	order   *list.List // of *snapshot, most recently used first
+	entries map[string]*list.Element
+}
+
+type snapshot struct {
+	path  string
+	hosts []string
+}
+
+// snapshotCache is shared by every node of a run, sized by -snapshot-cache
+var snapshotCache = NewSnapshotCache(0)
+
+// NewSnapshotCache creates a cache holding up to limit snapshots. With a
+// limit of 0 nothing is cached.
+func NewSnapshotCache(limit int) *SnapshotCache {
+	return &SnapshotCache{
+		Limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Hosts returns the hosts of the snapshot at path, one per line of the file,
+// keyed with bitnodeKey. The slice is shared and must not be
+// modified. Reading the file stops with ctx.Err() once ctx is done, and
+// nothing is cached then.
+func (cache *SnapshotCache) Hosts(ctx context.Context, path string) ([]string, error) {
+	if element, ok := cache.entries[path]; ok {
+		cache.order.MoveToFront(element)
+		return element.Value.(*snapshot).hosts, nil
+	}
+
+	hosts, err := readSnapshot(ctx, path)
+	if err != nil || cache.Limit <= 0 {
+		return hosts, err
+	}
+
+	cache.entries[path] = cache.order.PushFront(&snapshot{path, hosts})
+	for cache.order.Len() > cache.Limit {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*snapshot).path)
+	}
+	return hosts, nil
+}
+
+// readSnapshot reads the hosts of a bitnode file
+func readSnapshot(ctx context.Context, path string) ([]string, error) {
+	bitnodeFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read bitnode file %s", path)
+	}
+	defer bitnodeFile.Close()
+
+	scanner := bufio.NewScanner(bitnodeFile)
+	bufSize := bufio.MaxScanTokenSize
+	if MaxBitnodeLineSize < bufSize {
+		bufSize = MaxBitnodeLineSize
+	}
+	scanner.Buffer(make([]byte, bufSize), MaxBitnodeLineSize)
+
+	var hosts []string
+	for scanner.Scan() {
+		if len(hosts)%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		hosts = append(hosts, bitnodeKey(scanner.Text()))
+	}
+	// a line longer than the buffer stops the scan, which would otherwise
+	// silently under-count the reachable IPs
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't scan bitnode file %s: %s", path, err)
+	}
+	return hosts, nil
+}
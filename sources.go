@@ -0,0 +1,119 @@
+package main
+
+import "sort"
+
+// NetworkPair is a (source network, address network) combination
+type NetworkPair struct {
+	Source  Network
+	Address Network
+}
+
+// SourceAnomaly describes a source that advertised addresses outside its own
+// network
+type SourceAnomaly struct {
+	Source        string
+	SourceNetwork Network
+	Mismatched    int
+	Total         int
+}
+
+// SourceService returns the address of the peer that told the node about
+// the entry. Sources carry no port, so Port is 0. In addrv2 files they're
+// tagged with their BIP155 network like the address itself, which tells
+// onion and i2p sources apart.
+func (info CAddrInfo) SourceService() CService {
+	return CService{IPAddress: info.Source, NetworkID: info.SourceNetworkID}
+}
+
+func sourceNetwork(info CAddrInfo) Network {
+	return info.SourceService().Network()
+}
+
+// SourceNetworkCrossTab counts entries by the network of their source
+// against the network of the advertised address
+func SourceNetworkCrossTab(infos []CAddrInfo) map[NetworkPair]int {
+	counts := make(map[NetworkPair]int)
+	for _, info := range infos {
+		pair := NetworkPair{
+			Source:  sourceNetwork(info),
+			Address: info.Address.PeerAddress.Network(),
+		}
+		counts[pair]++
+	}
+	return counts
+}
+
+// SourceNetworkAnomalies returns the Tor sources which advertised at least
+// threshold clearnet addresses, ordered by the number they advertised.
+// Clearnet sources relaying each other's networks is normal for dual stack
+// peers, but an onion peer feeding a node clearnet addresses is suspicious.
+func SourceNetworkAnomalies(infos []CAddrInfo, threshold int) []SourceAnomaly {
+	bySource := make(map[string]*SourceAnomaly)
+	for _, info := range infos {
+		if sourceNetwork(info) != NetworkTor {
+			continue
+		}
+
+		source := info.SourceService().Host()
+		anomaly, ok := bySource[source]
+		if !ok {
+			anomaly = &SourceAnomaly{Source: source, SourceNetwork: NetworkTor}
+			bySource[source] = anomaly
+		}
+		anomaly.Total++
+
+		switch info.Address.PeerAddress.Network() {
+		case NetworkIPv4, NetworkIPv6:
+			anomaly.Mismatched++
+		}
+	}
+
+	var anomalies []SourceAnomaly
+	for _, anomaly := range bySource {
+		if anomaly.Mismatched >= threshold {
+			anomalies = append(anomalies, *anomaly)
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Mismatched != anomalies[j].Mismatched {
+			return anomalies[i].Mismatched > anomalies[j].Mismatched
+		}
+		return anomalies[i].Source < anomalies[j].Source
+	})
+	return anomalies
+}
+
+// SourceInfo is a peer which advertised addresses to the node
+type SourceInfo struct {
+	Source  string
+	Network Network
+	Count   int
+}
+
+// UniqueSources returns the distinct sources of the tabled addresses with
+// the number of addresses each contributed, most prolific first. Sources
+// are stored without a port, so they are keyed by host alone.
+func (peersDB PeersDB) UniqueSources() []SourceInfo {
+	bySource := make(map[string]*SourceInfo)
+	peersDB.Iterate(func(info CAddrInfo, _ TableKind) bool {
+		source := info.SourceService()
+		host := source.Host()
+		if _, ok := bySource[host]; !ok {
+			bySource[host] = &SourceInfo{Source: host, Network: source.Network()}
+		}
+		bySource[host].Count++
+		return true
+	})
+
+	sources := make([]SourceInfo, 0, len(bySource))
+	for _, source := range bySource {
+		sources = append(sources, *source)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Count != sources[j].Count {
+			return sources[i].Count > sources[j].Count
+		}
+		return sources[i].Source < sources[j].Source
+	})
+	return sources
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// the longest CAddrInfo the addrv2 format allows: version, time, services,
+// address, port, source, last success and attempts
+const maxCAddrInfoSize = 4 + 4 + 9 + (1 + 9 + maxAddrV2Size) + 2 + (1 + 9 + maxAddrV2Size) + 8 + 4
+
+// NewPeersDBReader parses a peers file from r one entry at a time, handing
+// each to yield with its table rather than keeping it, so memory use stays
+// the same whatever the size of the file. Returning false from yield stops
+// parsing early. The returned PeersDB holds the header only, its tables are
+// left empty. The bucket layout after the tables is not parsed.
+//
+// A gzip compressed stream is detected and decompressed as it is read.
+// Entries are parsed into fresh memory, so yield may keep them. As with
+// NewPeersDB, a file ending early gives an error wrapping ErrTruncated
+// after yielding the entries read before it, and a complete file failing
+// its checksum ErrChecksumMismatch after yielding every entry. A stream
+// stopped early by yield isn't checked.
+func NewPeersDBReader(r io.Reader, yield func(info CAddrInfo, table TableKind) bool) (PeersDB, error) {
+	var peersDB PeersDB
+	reader := bufio.NewReaderSize(r, maxCAddrInfoSize)
+
+	// decompress gzip streams on the fly
+	if magic, _ := reader.Peek(len(gzipMagic)); isGzip(magic) {
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return peersDB, fmt.Errorf("gzip decompression failed: %w", err)
+		}
+		defer decompressor.Close()
+		reader = bufio.NewReaderSize(decompressor, maxCAddrInfoSize)
+	}
+
+	header := make([]byte, peersHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		return peersDB, fmt.Errorf("%w parsing header at byte offset %d", err, n)
+	}
+	// hashed as it is consumed, the entries before parsing rearranges them
+	hash := sha256.New()
+	hash.Write(header)
+	headerReader := DBReader{Bytes: header}
+	headerReader.readHeader(&peersDB)
+	if !isKnownMagic(peersDB.MessageBytes) {
+		return peersDB, fmt.Errorf("%w %s", ErrBadMagic, hexstring(peersDB.MessageBytes))
+	}
+	if err := checkVersion(peersDB); err != nil {
+		return peersDB, err
+	}
+	if err := checkCounts(peersDB); err != nil {
+		return peersDB, err
+	}
+
+	offset := uint64(peersHeaderSize)
+	for _, table := range []struct {
+		kind  TableKind
+		count uint32
+	}{{TableNew, peersDB.NNew}, {TableTried, peersDB.NTried}} {
+		for i := uint32(0); i < table.count; i++ {
+			// a short peek at the end of the file is caught by
+			// peekCAddrInfoSize, other read errors aren't
+			window, err := reader.Peek(maxCAddrInfoSize)
+			if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+				return peersDB, fmt.Errorf("Couldn't read %s table entry %d at byte offset %d: %w", table.kind, i, offset, err)
+			}
+
+			entryReader := DBReader{Bytes: window}
+			size, ok := entryReader.peekCAddrInfoSize()
+			if !ok {
+				return peersDB, fmt.Errorf("%w parsing %s table entry %d at byte offset %d", ErrTruncated, table.kind, i, offset)
+			}
+
+			// the parsed fields point into the bytes they were read from,
+			// which the next peek overwrites
+			entryReader.Bytes = append([]byte{}, window[:size]...)
+			hash.Write(entryReader.Bytes)
+			info := entryReader.readCAddrInfo()
+			reader.Discard(int(size))
+			offset += size
+
+			if !yield(info, table.kind) {
+				return peersDB, nil
+			}
+		}
+	}
+
+	// the bucket layout runs up to the trailing checksum
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return peersDB, fmt.Errorf("Couldn't read bucket layout at byte offset %d: %w", offset, err)
+	}
+	if len(rest) < checksumSize {
+		return peersDB, fmt.Errorf("%w parsing checksum at byte offset %d", ErrTruncated, offset)
+	}
+	end := len(rest) - checksumSize
+	hash.Write(rest[:end])
+	checksum := sha256.Sum256(hash.Sum(nil))
+	peersDB.checksumValid = bytes.Equal(checksum[:], rest[end:])
+	if !peersDB.checksumValid {
+		return peersDB, fmt.Errorf("%w: stream may be corrupt", ErrChecksumMismatch)
+	}
+	return peersDB, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPeersDBReaderMatchesNewPeersDB(t *testing.T) {
+	data := legacyFixture(50, 1700000000).bytes()
+	peersDB, err := NewPeersDB(writeFixture(t, "peers.dat", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed []CAddrInfo
+	header, err := NewPeersDBReader(bytes.NewReader(data), func(info CAddrInfo, table TableKind) bool {
+		streamed = append(streamed, info)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.VerifyChecksum() || header.NNew != peersDB.NNew || header.NTried != peersDB.NTried {
+		t.Errorf("got header %d new, %d tried, checksum valid %t", header.NNew, header.NTried, header.VerifyChecksum())
+	}
+
+	var parsed []CAddrInfo
+	peersDB.Iterate(func(info CAddrInfo, table TableKind) bool {
+		info.BucketIndex, info.BucketPosition = -1, -1
+		parsed = append(parsed, info)
+		return true
+	})
+	if !reflect.DeepEqual(streamed, parsed) {
+		t.Error("streamed entries differ from the parsed ones")
+	}
+}
+
+func TestPeersDBReaderChecksum(t *testing.T) {
+	data := legacyFixture(10, 1700000000).bytes()
+	data[len(data)-checksumSize-1] ^= 1
+
+	count := 0
+	_, err := NewPeersDBReader(bytes.NewReader(data), func(CAddrInfo, TableKind) bool {
+		count++
+		return true
+	})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want ErrChecksumMismatch", err)
+	}
+	if count != 13 {
+		t.Errorf("yielded %d entries before the mismatch, want 13", count)
+	}
+
+	_, err = NewPeersDBReader(bytes.NewReader(data[:peersHeaderSize+100]), func(CAddrInfo, TableKind) bool { return true })
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("got %v for a file cut short, want ErrTruncated", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Summary describes a single table's result in a short English sentence,
+// e.g. "41 entries, 18 reachable (44%, 0.2% of the 9,000 node snapshot);
+// oldest address 39 days"
+func Summary(result *Result) string {
+	if result.TotalIPs == 0 {
+		return "no entries"
+	}
+
+	if result.NoCrawlData {
+		return fmt.Sprintf("%s entries, reachability unknown (no crawl data); oldest address %d days",
+			thousands(result.TotalIPs), oldestDays(result))
+	}
+
+	return fmt.Sprintf("%s entries, %s reachable (%.0f%%, %.1f%% of the %s node snapshot); oldest address %d days",
+		thousands(result.TotalIPs), thousands(result.NumberOfReachableIPs),
+		result.Percentage*100, result.NetworkCoverage()*100, thousands(result.SnapshotNetworkSize),
+		oldestDays(result))
+}
+
+// Report combines both tables into a human-readable report headed by label,
+// typically the node's directory name
+func Report(label string, newResult, triedResult *Result) string {
+	known := newResult.TotalIPs + triedResult.TotalIPs
+	if known == 0 {
+		return fmt.Sprintf("%s: no entries.", label)
+	}
+
+	date := time.Unix(int64(newResult.ApproxAge), 0).Format("Jan 2 2006")
+
+	tried := "no tried entries"
+	if triedResult.NoCrawlData {
+		tried = "no crawl data"
+	} else if triedResult.TotalIPs > 0 {
+		tried = fmt.Sprintf("%.0f%% of tried reachable", triedResult.Percentage*100)
+	}
+
+	oldest := 0
+	for _, result := range []*Result{newResult, triedResult} {
+		if result.TotalIPs > 0 && oldestDays(result) > oldest {
+			oldest = oldestDays(result)
+		}
+	}
+
+	return fmt.Sprintf("%s: %s known (%s tried), %s as of %s; oldest address %d days.\n  new: %s\n  tried: %s",
+		label, thousands(known), thousands(triedResult.TotalIPs), tried, date, oldest,
+		Summary(newResult), Summary(triedResult))
+}
+
+func oldestDays(result *Result) int {
+	return (int(result.Reference) - int(result.OldestIPAge)) / ONE_DAY
+}
+
+// thousands formats n with comma separators
+func thousands(n int) string {
+	if n < 0 {
+		return "-" + thousands(-n)
+	}
+	digits := strconv.Itoa(n)
+
+	var out []byte
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, digits[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,88 @@
+package main
+
+// TableKind identifies which of addrman's tables an entry is kept in
+type TableKind uint8
+
+const (
+	TableNew TableKind = iota
+	TableTried
+)
+
+func (table TableKind) String() string {
+	if table == TableTried {
+		return "tried"
+	}
+	return "new"
+}
+
+// Iterate calls yield with every entry of the new table followed by every
+// entry of the tried table, stopping early once yield returns false. It saves
+// callers which don't care where an entry is kept from walking both slices.
+func (peersDB PeersDB) Iterate(yield func(info CAddrInfo, table TableKind) bool) {
+	for _, info := range peersDB.NewAddrInfo {
+		if !yield(info, TableNew) {
+			return
+		}
+	}
+	for _, info := range peersDB.TriedAddrInfo {
+		if !yield(info, TableTried) {
+			return
+		}
+	}
+}
+
+// Len returns the number of entries in both tables
+func (peersDB PeersDB) Len() int {
+	return len(peersDB.NewAddrInfo) + len(peersDB.TriedAddrInfo)
+}
+
+// NewAddresses returns a deep copy of the new table, which can be sorted,
+// filtered or modified in place without touching the PeersDB, see Clone.
+// UnsafeNewAddrInfo avoids the copy where that matters.
+func (peersDB PeersDB) NewAddresses() []CAddrInfo {
+	return cloneTable(peersDB.NewAddrInfo)
+}
+
+// TriedAddresses returns a deep copy of the tried table, see NewAddresses
+func (peersDB PeersDB) TriedAddresses() []CAddrInfo {
+	return cloneTable(peersDB.TriedAddrInfo)
+}
+
+// UnsafeNewAddrInfo returns the new table itself, without copying. Its
+// entries' byte slices point into the parsed file, so changes through it
+// change the PeersDB and, for a mapped file, fault once it is released.
+func (peersDB PeersDB) UnsafeNewAddrInfo() []CAddrInfo {
+	return peersDB.NewAddrInfo
+}
+
+// UnsafeTriedAddrInfo returns the tried table itself, see UnsafeNewAddrInfo
+func (peersDB PeersDB) UnsafeTriedAddrInfo() []CAddrInfo {
+	return peersDB.TriedAddrInfo
+}
+
+func cloneTable(infos []CAddrInfo) []CAddrInfo {
+	clones := make([]CAddrInfo, len(infos))
+	for i, info := range infos {
+		clones[i] = info.Clone()
+	}
+	return clones
+}
+
+// Clone returns a copy of the entry sharing no memory with it: the
+// serialization version, service flags, address and source are copied
+func (cAddrInfo CAddrInfo) Clone() CAddrInfo {
+	clone := cAddrInfo
+	clone.Address.SerializationVersion = cloneBytes(cAddrInfo.Address.SerializationVersion)
+	clone.Address.ServiceFlags = cloneBytes(cAddrInfo.Address.ServiceFlags)
+	clone.Address.PeerAddress.IPAddress = cloneBytes(cAddrInfo.Address.PeerAddress.IPAddress)
+	clone.Source = cloneBytes(cAddrInfo.Source)
+	return clone
+}
+
+// cloneBytes copies b, keeping nil as nil
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
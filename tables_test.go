@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAddressesAreDeepCopies(t *testing.T) {
+	peersDB := parseFixture(t, legacyFixture(8, 1700000000))
+	for name, table := range map[string]func() []CAddrInfo{"new": peersDB.NewAddresses, "tried": peersDB.TriedAddresses} {
+		want := table()[0].Clone()
+
+		copied := table()
+		copied[0].Address.Time = 0
+		copied[0].Address.PeerAddress.IPAddress[15] ^= 0xff
+		copied[0].Address.ServiceFlags[7] ^= 0xff
+		copied[0].Address.SerializationVersion[0] ^= 0xff
+		copied[0].Source[15] ^= 0xff
+
+		got := table()[0]
+		if got.Address.Time != want.Address.Time || !got.Address.PeerAddress.IPAddress.Equal(want.Address.PeerAddress.IPAddress) ||
+			got.Address.Services() != want.Address.Services() || got.Address.SerializationVersion[0] != want.Address.SerializationVersion[0] ||
+			!got.Source.Equal(want.Source) {
+			t.Errorf("changing a copy of the %s table changed the PeersDB", name)
+		}
+	}
+
+	if &peersDB.UnsafeNewAddrInfo()[0] != &peersDB.NewAddrInfo[0] || &peersDB.UnsafeTriedAddrInfo()[0] != &peersDB.TriedAddrInfo[0] {
+		t.Error("the unsafe accessors copied the tables")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultServiceCeiling is the lowest service bit considered implausible.
+// Bits 24 to 31 are reserved for experiments, so anything from bit 32 up is
+// almost certainly garbage from a misaligned parse.
+const DefaultServiceCeiling = 32
+
+// HasImplausibleServices reports whether the entry advertises any service
+// bit at or above ceiling
+func HasImplausibleServices(ceiling uint) Predicate {
+	return func(info CAddrInfo) bool {
+		return ceiling < 64 && info.Address.Services()>>ceiling != 0
+	}
+}
+
+// maxProblems caps the problems Validate lists individually, so that a
+// thoroughly corrupt file doesn't produce one error per entry
+const maxProblems = 20
+
+// Validate checks the parsed database for internal consistency: a known
+// network magic and format version, header counts matching the parsed
+// tables and an address in every entry. All problems found are returned
+// together, joined with errors.Join; nil means none were.
+func (peersDB PeersDB) Validate() error {
+	var problems []error
+	if !isKnownMagic(peersDB.MessageBytes) {
+		problems = append(problems, fmt.Errorf("%w %s", ErrBadMagic, hexstring(peersDB.MessageBytes)))
+	}
+	if err := checkVersion(peersDB); err != nil {
+		problems = append(problems, err)
+	}
+	if int(peersDB.NNew) != len(peersDB.NewAddrInfo) {
+		problems = append(problems, fmt.Errorf("Header declares %d new entries but %d were parsed", peersDB.NNew, len(peersDB.NewAddrInfo)))
+	}
+	if int(peersDB.NTried) != len(peersDB.TriedAddrInfo) {
+		problems = append(problems, fmt.Errorf("Header declares %d tried entries but %d were parsed", peersDB.NTried, len(peersDB.TriedAddrInfo)))
+	}
+
+	empty := 0
+	for _, table := range []struct {
+		name  string
+		infos []CAddrInfo
+	}{{"new", peersDB.NewAddrInfo}, {"tried", peersDB.TriedAddrInfo}} {
+		for i, info := range table.infos {
+			if len(info.Address.PeerAddress.IPAddress) != 0 {
+				continue
+			}
+			if empty++; empty <= maxProblems {
+				problems = append(problems, fmt.Errorf("%s entry %d has no address", table.name, i))
+			}
+		}
+	}
+	if empty > maxProblems {
+		problems = append(problems, fmt.Errorf("%d more entries have no address", empty-maxProblems))
+	}
+
+	return errors.Join(problems...)
+}